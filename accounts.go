@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/spf13/viper"
+)
+
+// accountConfig is the per-account configuration of config.json. An account entry is either the API key
+// itself (legacy form) or an object; unset fields fall back to the global settings.
+type accountConfig struct {
+	APIKey          string            `mapstructure:"api_key"`            // API key in clear text
+	APIKeyEnv       string            `mapstructure:"api_key_env"`        // Environment variable holding the API key
+	APIKeyVaultPath string            `mapstructure:"api_key_vault_path"` // Vault KV v2 path with an api_key field
+	Regions         []string          `mapstructure:"regions"`            // Replaces the requested regions for this account
+	ResourceGroups  []string          `mapstructure:"resource_groups"`    // Replaces the requested resource groups for this account
+	Tags            []string          `mapstructure:"tags"`               // Only instances carrying every tag are discovered
+	Endpoints       map[string]string `mapstructure:"endpoints"`          // Service endpoint overrides, see defaultEndpoints
+}
+
+// Services whose endpoint can be overridden per account, e.g. to use private endpoints.
+// {region} is replaced by the region for regional services.
+var defaultEndpoints = map[string]string{
+	"iam":                 "https://iam.cloud.ibm.com",
+	"vpc":                 "https://{region}.iaas.cloud.ibm.com/v1",
+	"global_tagging":      "https://tags.global-search-tagging.cloud.ibm.com",
+	"global_search":       "https://api.global-search-tagging.cloud.ibm.com",
+	"resource_manager":    "https://resource-controller.cloud.ibm.com",
+	"resource_controller": "https://resource-controller.cloud.ibm.com",
+	"power_iaas":          "https://{region}.power-iaas.cloud.ibm.com",
+	"containers":          containersAPIURL,
+	"classic":             classicAPIURL,
+}
+
+var (
+	accountConfigsMu sync.Mutex
+	accountConfigs   map[string]accountConfig // Snapshot of the accounts section, keyed by lower-case account name
+)
+
+// loadAccountConfig returns the configuration of an account from the current snapshot. Discovery goroutines
+// call it for every client lookup, so it never touches viper once the snapshot exists.
+func loadAccountConfig(account string) accountConfig {
+	accountConfigsMu.Lock()
+	defer accountConfigsMu.Unlock()
+	if accountConfigs == nil {
+		accountConfigs = snapshotAccountConfigs(viper.GetViper()) // Configuration read at startup
+	}
+	return accountConfigs[strings.ToLower(account)]
+}
+
+// reloadAccountConfigs reads config.json again and replaces the account snapshot, so a changed api_key or
+// endpoint takes effect on the next discovery. It runs once per discovery cycle with its own viper instance:
+// viper is not safe for concurrent use, and the global instance is only read after startup.
+func reloadAccountConfigs() {
+	config := viper.New()
+	config.SetConfigName("config")
+	config.SetConfigType("json")
+	config.AddConfigPath(".")
+	if err := config.ReadInConfig(); err != nil {
+		return // Keep the current snapshot
+	}
+
+	configs := snapshotAccountConfigs(config)
+	accountConfigsMu.Lock()
+	defer accountConfigsMu.Unlock()
+	accountConfigs = configs
+}
+
+// snapshotAccountConfigs decodes every account entry, accepting both the string and the object form
+func snapshotAccountConfigs(config *viper.Viper) map[string]accountConfig {
+	configs := make(map[string]accountConfig)
+	for account := range config.GetStringMap("accounts") {
+		var accountConfig accountConfig
+		switch value := config.Get("accounts." + account).(type) {
+		case string:
+			accountConfig.APIKey = value
+		case map[string]interface{}:
+			if err := config.UnmarshalKey("accounts."+account, &accountConfig); err != nil {
+				log.Printf("⚠️ Warning: Invalid configuration for account %s: %v", maskAccount(account), err)
+			}
+		}
+		configs[strings.ToLower(account)] = accountConfig
+	}
+	return configs
+}
+
+// scope returns the regions and resource groups to discover for the account, the account's own settings
+// taking precedence over the requested ones
+func (c accountConfig) scope(regionList, resourceGroupList []string) ([]string, []string) {
+	if len(c.Regions) > 0 {
+		regionList = c.Regions
+	}
+	if len(c.ResourceGroups) > 0 {
+		resourceGroupList = c.ResourceGroups
+	}
+	return regionList, resourceGroupList
+}
+
+// filterTags keeps the instances carrying every tag configured for the account
+func (c accountConfig) filterTags(instances []Instance) []Instance {
+	if len(c.Tags) == 0 {
+		return instances
+	}
+
+	filtered := []Instance{}
+	for _, instance := range instances {
+		matches := true
+		for _, tag := range c.Tags {
+			if !contains(instance.Tags, tag) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
+
+// configuredAPIKey returns the API key from the account's api_key_env, api_key or api_key_vault_path settings
+func (c accountConfig) configuredAPIKey(account string) (string, bool) {
+	if c.APIKeyEnv != "" {
+		if key := os.Getenv(c.APIKeyEnv); key != "" {
+			return key, true
+		}
+		log.Printf("⚠️ Warning: Environment variable %s for account %s is empty", c.APIKeyEnv, maskAccount(account))
+	}
+	if c.APIKey != "" {
+		return c.APIKey, true
+	}
+	if c.APIKeyVaultPath != "" {
+		if key, err := readVaultAPIKey(c.APIKeyVaultPath); err == nil {
+			return key, true
+		} else {
+			log.Printf("⚠️ Warning: Could not read API key of account %s from Vault: %v", maskAccount(account), err)
+		}
+	}
+	return "", false
+}
+
+// endpoint returns the URL of a service for an account: its override from config.json or the public endpoint.
+// region fills the {region} placeholder of regional services.
+func endpoint(account, service, region string) string {
+	serviceURL := defaultEndpoints[service]
+	if override := loadAccountConfig(account).Endpoints[service]; override != "" {
+		serviceURL = override
+	}
+	return strings.TrimSuffix(strings.ReplaceAll(serviceURL, "{region}", region), "/")
+}
+
+// endpointOverride returns the configured URL of an SDK service, whose own default is used otherwise
+func endpointOverride(account, service string) (string, bool) {
+	override := loadAccountConfig(account).Endpoints[service]
+	return strings.TrimSuffix(override, "/"), override != ""
+}
+
+// newAuthenticator creates the IAM authenticator of an account, honoring an iam endpoint override
+func newAuthenticator(account, apiKey string) *core.IamAuthenticator {
+	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
+	if iamURL, found := endpointOverride(account, "iam"); found {
+		authenticator.URL = iamURL
+	}
+	return authenticator
+}
+
+// configDefaultList reads a global list setting given as "a,b", ["a", "b"] or {"default": "a,b"}
+func configDefaultList(key string) []string {
+	switch value := viper.Get(key).(type) {
+	case string:
+		return splitNonEmpty(value)
+	case []interface{}:
+		list := []string{}
+		for _, item := range value {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	case map[string]interface{}:
+		if defaultValue, found := value["default"]; found {
+			if list, ok := defaultValue.([]interface{}); ok {
+				items := []string{}
+				for _, item := range list {
+					items = append(items, fmt.Sprint(item))
+				}
+				return items
+			}
+			return splitNonEmpty(fmt.Sprint(defaultValue))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+)
+
+const (
+	resourceTypeInstance        = "instance"
+	resourceTypeBareMetalServer = "bare_metal_server"
+)
+
+// fetchBareMetalServers lists VPC bare metal servers in a region and maps them into the Instance model
+func fetchBareMetalServers(vpcService *vpcv1.VpcV1, region, account, resourceGroupID string, floatingIPMap map[string]string, vniAddresses map[string][]string) ([]Instance, error) {
+	servers := []Instance{}
+	options := vpcService.NewListBareMetalServersOptions()
+	if resourceGroupID != "" {
+		options.SetResourceGroupID(resourceGroupID)
+	}
+
+	for {
+		result, response, err := retryCall("vpc", "ListBareMetalServers", vpcService.ListBareMetalServers, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list bare metal servers in %s: %v (HTTP %d)", region, err, statusCode(response))
+		}
+
+		for _, server := range result.BareMetalServers {
+			interfaces := bareMetalServerInterfaces(server, floatingIPMap, vniAddresses)
+			privateIP, publicIP := primaryAddresses(interfaces)
+
+			profile := ""
+			if server.Profile != nil && server.Profile.Name != nil {
+				profile = *server.Profile.Name
+			}
+
+			zone := ""
+			if server.Zone != nil && server.Zone.Name != nil {
+				zone = *server.Zone.Name
+			}
+
+			servers = append(servers, Instance{
+				Name:             *server.Name,
+				ID:               *server.ID,
+				Region:           region,
+				Account:          account,
+				Status:           *server.Status,
+				AvailabilityZone: zone,
+				InstanceID:       *server.CRN,
+				PrivateIP:        privateIP,
+				PublicIP:         publicIP,
+				PrivateIPv6:      primaryIPv6(interfaces),
+				Profile:          profile,
+				ResourceType:     resourceTypeBareMetalServer,
+				Interfaces:       interfaces,
+			})
+		}
+
+		if result.Next == nil {
+			break
+		}
+		start := nextPageStart(result.Next.Href, region)
+		if start == "" {
+			break
+		}
+		options.SetStart(start)
+	}
+
+	log.Printf("✅ Fetched %d bare metal servers for region '%s'", len(servers), region)
+	return servers, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	resourceTypeClassicVirtualGuest = "classic_virtual_guest"
+	resourceTypeClassicBareMetal    = "classic_bare_metal"
+
+	classicAPIURL    = "https://api.softlayer.com/rest/v3.1"
+	classicPageLimit = 100
+
+	classicGuestMask    = "mask[id,globalIdentifier,hostname,domain,primaryIpAddress,primaryBackendIpAddress,primaryNetworkComponent[primaryVersion6IpAddressRecord[ipAddress]],maxCpu,maxMemory,datacenter[name],powerState[keyName],tagReferences[tag[name]]]"
+	classicHardwareMask = "mask[id,globalIdentifier,hostname,domain,primaryIpAddress,primaryBackendIpAddress,primaryNetworkComponent[primaryVersion6IpAddressRecord[ipAddress]],processorPhysicalCoreAmount,memoryCapacity,datacenter[name],hardwareStatus[status],tagReferences[tag[name]]]"
+)
+
+// classicDevice is the subset of SoftLayer_Virtual_Guest and SoftLayer_Hardware used for discovery
+type classicDevice struct {
+	ID                      int64  `json:"id"`
+	GlobalIdentifier        string `json:"globalIdentifier"`
+	Hostname                string `json:"hostname"`
+	Domain                  string `json:"domain"`
+	PrimaryIPAddress        string `json:"primaryIpAddress"`
+	PrimaryBackendIPAddress string `json:"primaryBackendIpAddress"`
+	PrimaryNetworkComponent struct {
+		PrimaryVersion6IPAddressRecord struct {
+			IPAddress string `json:"ipAddress"`
+		} `json:"primaryVersion6IpAddressRecord"`
+	} `json:"primaryNetworkComponent"` // Public IPv6 address, only set when IPv6 was ordered
+	MaxCPU         int64 `json:"maxCpu"`
+	MaxMemory      int64 `json:"maxMemory"` // MB
+	PhysicalCores  int64 `json:"processorPhysicalCoreAmount"`
+	MemoryCapacity int64 `json:"memoryCapacity"` // GB
+	Datacenter     struct {
+		Name string `json:"name"`
+	} `json:"datacenter"`
+	PowerState struct {
+		KeyName string `json:"keyName"`
+	} `json:"powerState"`
+	HardwareStatus struct {
+		Status string `json:"status"`
+	} `json:"hardwareStatus"`
+	TagReferences []struct {
+		Tag struct {
+			Name string `json:"name"`
+		} `json:"tag"`
+	} `json:"tagReferences"`
+}
+
+// fetchClassicInstances lists classic infrastructure virtual guests and bare metal servers of an account.
+// Classic devices are not part of resource groups, so the resource group filter does not apply.
+func fetchClassicInstances(apiKey, account string, resourceGroups []string) ([]Instance, error) {
+	baseURL := endpoint(account, "classic", "")
+	guests, err := listClassicDevices(apiKey, baseURL, "SoftLayer_Account/getVirtualGuests", classicGuestMask)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list classic virtual guests: %v", err)
+	}
+
+	hardware, err := listClassicDevices(apiKey, baseURL, "SoftLayer_Account/getHardware", classicHardwareMask)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list classic bare metal servers: %v", err)
+	}
+
+	instances := []Instance{}
+	for _, guest := range guests {
+		status := strings.ToLower(guest.PowerState.KeyName)
+		if status == "halted" {
+			status = "stopped"
+		}
+		instances = append(instances, classicInstance(guest, account, resourceTypeClassicVirtualGuest, status,
+			fmt.Sprintf("%dx%d", guest.MaxCPU, guest.MaxMemory/1024)))
+	}
+	for _, server := range hardware {
+		status := strings.ToLower(server.HardwareStatus.Status)
+		if status == "active" {
+			status = "running"
+		}
+		instances = append(instances, classicInstance(server, account, resourceTypeClassicBareMetal, status,
+			fmt.Sprintf("%dx%d", server.PhysicalCores, server.MemoryCapacity)))
+	}
+
+	log.Printf("✅ Fetched %d classic virtual guests and %d classic bare metal servers for account %s", len(guests), len(hardware), maskAccount(account))
+	return instances, nil
+}
+
+// classicInstance maps a classic device into the Instance model; the datacenter becomes the zone
+func classicInstance(device classicDevice, account, resourceType, status, profile string) Instance {
+	tags := []string{}
+	for _, reference := range device.TagReferences {
+		if reference.Tag.Name != "" {
+			tags = append(tags, reference.Tag.Name)
+		}
+	}
+
+	return Instance{
+		Name:             device.Hostname,
+		ID:               strconv.FormatInt(device.ID, 10),
+		Region:           regionForDatacenter(device.Datacenter.Name),
+		Account:          account,
+		PrivateIP:        device.PrimaryBackendIPAddress,
+		PublicIP:         device.PrimaryIPAddress,
+		PublicIPv6:       device.PrimaryNetworkComponent.PrimaryVersion6IPAddressRecord.IPAddress,
+		Status:           status,
+		AvailabilityZone: device.Datacenter.Name,
+		InstanceID:       device.GlobalIdentifier,
+		Profile:          profile,
+		Tags:             tags,
+		ResourceType:     resourceType,
+		Hostname:         device.Hostname,
+		Domain:           device.Domain,
+	}
+}
+
+// listClassicDevices pages through a SoftLayer account method using the IBM Cloud API key
+func listClassicDevices(apiKey, baseURL, method, objectMask string) ([]classicDevice, error) {
+	devices := []classicDevice{}
+
+	for offset := 0; ; offset += classicPageLimit {
+		query := url.Values{}
+		query.Set("objectMask", objectMask)
+		query.Set("resultLimit", fmt.Sprintf("%d,%d", offset, classicPageLimit))
+		requestURL := fmt.Sprintf("%s/%s.json?%s", baseURL, method, query.Encode())
+
+		resp, err := retryRequest("classic", method, func() (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			// The classic API accepts IBM Cloud API keys with the fixed user name "apikey"
+			req.SetBasicAuth("apikey", apiKey)
+			return req, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var page []classicDevice
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s response: %v", method, err)
+		}
+
+		devices = append(devices, page...)
+		if len(page) < classicPageLimit {
+			break
+		}
+	}
+
+	return devices, nil
+}
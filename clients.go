@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/IBM/platform-services-go-sdk/globalsearchv2"
+	"github.com/IBM/platform-services-go-sdk/globaltaggingv1"
+	"github.com/IBM/platform-services-go-sdk/resourcecontrollerv2"
+	"github.com/IBM/platform-services-go-sdk/resourcemanagerv2"
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+)
+
+// accountClients holds the SDK clients of one account. They share a single IAM authenticator, so the API key
+// is exchanged for a token once and the token is reused until it is about to expire.
+type accountClients struct {
+	account       string
+	fingerprint   [sha256.Size]byte // API key and endpoint overrides the clients were created with
+	authenticator *core.IamAuthenticator
+
+	mu                 sync.Mutex
+	vpc                map[string]*vpcv1.VpcV1 // Keyed by region
+	tagging            *globaltaggingv1.GlobalTaggingV1
+	search             *globalsearchv2.GlobalSearchV2
+	resourceManager    *resourcemanagerv2.ResourceManagerV2
+	resourceController *resourcecontrollerv2.ResourceControllerV2
+}
+
+var (
+	clientRegistryMu sync.Mutex
+	clientRegistry   = make(map[string]*accountClients) // Keyed by account name
+)
+
+// clientsFor returns the clients of an account, creating them on first use. Clients are created again when the
+// API key or the endpoint overrides of the account have changed, e.g. after a key rotation.
+func clientsFor(account, apiKey string) *accountClients {
+	fingerprint := clientFingerprint(account, apiKey)
+
+	clientRegistryMu.Lock()
+	defer clientRegistryMu.Unlock()
+
+	clients, found := clientRegistry[account]
+	if found && clients.fingerprint == fingerprint {
+		return clients
+	}
+	if found {
+		log.Printf("🔑 Credentials or endpoints of account %s changed, creating new clients", maskAccount(account))
+	}
+
+	clients = &accountClients{
+		account:       account,
+		fingerprint:   fingerprint,
+		authenticator: newAuthenticator(account, apiKey),
+		vpc:           make(map[string]*vpcv1.VpcV1),
+	}
+	clientRegistry[account] = clients
+	return clients
+}
+
+// clientFingerprint identifies the API key and endpoint overrides without keeping the key itself around
+func clientFingerprint(account, apiKey string) [sha256.Size]byte {
+	endpoints, _ := json.Marshal(loadAccountConfig(account).Endpoints) // Map keys are marshalled sorted
+	return sha256.Sum256(append([]byte(apiKey+"\x00"), endpoints...))
+}
+
+// vpcClient returns the VPC client of a regional endpoint, "global" for the regions API, and its URL
+func (c *accountClients) vpcClient(region string) (*vpcv1.VpcV1, string, error) {
+	serviceURL := endpoint(c.account, "vpc", region)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if vpcService, found := c.vpc[region]; found {
+		return vpcService, serviceURL, nil
+	}
+
+	vpcService, err := vpcv1.NewVpcV1(&vpcv1.VpcV1Options{Authenticator: c.authenticator})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create VPC service: %v", err)
+	}
+	vpcService.SetServiceURL(serviceURL)
+	c.vpc[region] = vpcService
+	return vpcService, serviceURL, nil
+}
+
+// taggingClient returns the Global Tagging client
+func (c *accountClients) taggingClient() (*globaltaggingv1.GlobalTaggingV1, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tagging != nil {
+		return c.tagging, nil
+	}
+
+	taggingService, err := globaltaggingv1.NewGlobalTaggingV1(&globaltaggingv1.GlobalTaggingV1Options{
+		Authenticator: c.authenticator,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tagging service: %v", err)
+	}
+	if serviceURL, found := endpointOverride(c.account, "global_tagging"); found {
+		taggingService.SetServiceURL(serviceURL)
+	}
+	c.tagging = taggingService
+	return taggingService, nil
+}
+
+// searchClient returns the Global Search client
+func (c *accountClients) searchClient() (*globalsearchv2.GlobalSearchV2, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.search != nil {
+		return c.search, nil
+	}
+
+	searchService, err := globalsearchv2.NewGlobalSearchV2(&globalsearchv2.GlobalSearchV2Options{
+		Authenticator: c.authenticator,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create global search service: %v", err)
+	}
+	if serviceURL, found := endpointOverride(c.account, "global_search"); found {
+		searchService.SetServiceURL(serviceURL)
+	}
+	c.search = searchService
+	return searchService, nil
+}
+
+// resourceManagerClient returns the Resource Manager client
+func (c *accountClients) resourceManagerClient() (*resourcemanagerv2.ResourceManagerV2, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceManager != nil {
+		return c.resourceManager, nil
+	}
+
+	resourceManagerService, err := resourcemanagerv2.NewResourceManagerV2(&resourcemanagerv2.ResourceManagerV2Options{
+		Authenticator: c.authenticator,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource manager service: %v", err)
+	}
+	if serviceURL, found := endpointOverride(c.account, "resource_manager"); found {
+		resourceManagerService.SetServiceURL(serviceURL)
+	}
+	c.resourceManager = resourceManagerService
+	return resourceManagerService, nil
+}
+
+// resourceControllerClient returns the Resource Controller client
+func (c *accountClients) resourceControllerClient() (*resourcecontrollerv2.ResourceControllerV2, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resourceController != nil {
+		return c.resourceController, nil
+	}
+
+	controllerService, err := resourcecontrollerv2.NewResourceControllerV2(&resourcecontrollerv2.ResourceControllerV2Options{
+		Authenticator: c.authenticator,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource controller service: %v", err)
+	}
+	if serviceURL, found := endpointOverride(c.account, "resource_controller"); found {
+		controllerService.SetServiceURL(serviceURL)
+	}
+	c.resourceController = controllerService
+	return controllerService, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// discoverySnapshot holds the instances of the last completed discovery cycle
+type discoverySnapshot struct {
+	mu        sync.RWMutex
+	instances []Instance
+	updatedAt time.Time
+}
+
+var (
+	snapshot   = &discoverySnapshot{}
+	daemonMode bool // When set, HTTP handlers serve the snapshot instead of querying IBM Cloud
+
+	// schedulerRunning is set once background discovery has started. The scheduler then owns the file_sd
+	// output and its backups, so handlers must not write them.
+	schedulerRunning bool
+)
+
+func (s *discoverySnapshot) store(instances []Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances = instances
+	s.updatedAt = time.Now()
+}
+
+// load returns the snapshot and whether a discovery cycle has completed yet
+func (s *discoverySnapshot) load() ([]Instance, time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.instances, s.updatedAt, !s.updatedAt.IsZero()
+}
+
+// discoveryScheduler runs discovery for every configured account on a fixed interval plus jitter
+type discoveryScheduler struct {
+	accounts       []string
+	regions        []string
+	resourceGroups []string
+	interval       time.Duration
+	jitter         time.Duration
+	outputFile     string
+}
+
+// run executes a discovery cycle immediately and then keeps refreshing until the process exits
+func (d *discoveryScheduler) run() {
+	for {
+		d.runCycle()
+		if d.interval <= 0 {
+			return
+		}
+
+		delay := d.nextDelay()
+		log.Printf("🔄 Next discovery cycle in %s", delay.Round(time.Second))
+		time.Sleep(delay)
+	}
+}
+
+func (d *discoveryScheduler) runCycle() {
+	start := time.Now()
+	// Jobs may reference more accounts, regions and resource groups than the global configuration
+	instances := collectInstances(jobDiscoveryScope(d.accounts, d.regions, d.resourceGroups))
+	snapshot.store(instances)
+	log.Printf("✅ Discovery cycle completed: %d instances in %s", len(instances), time.Since(start).Round(time.Millisecond))
+
+	if d.outputFile != "" {
+		targetGroups := buildPrometheusTargetGroups(filterScope(instances, d.accounts, d.regions, d.resourceGroups), defaultTargetOptions())
+		if err := writeSDConfig(d.outputFile, applyRelabelConfigs(targetGroups, fileSDRelabelConfigs), sdBackups); err != nil {
+			log.Printf("❌ Error writing Prometheus file %s: %v", d.outputFile, err)
+		}
+	}
+	writeJobOutputs(instances)
+}
+
+// nextDelay spreads refreshes of several tool instances so they don't hit the IBM APIs at the same moment
+func (d *discoveryScheduler) nextDelay() time.Duration {
+	if d.jitter <= 0 {
+		return d.interval
+	}
+	return d.interval + time.Duration(rand.Int63n(int64(d.jitter)))
+}
+
+// instancesForRequest returns the instances a handler should serve: the filtered snapshot in daemon mode,
+// otherwise a live fetch. The boolean is false when the daemon has not completed its first cycle yet.
+func instancesForRequest(accountList, regionList, resourceGroupList []string) ([]Instance, bool) {
+	if !daemonMode {
+		return collectInstances(accountList, regionList, resourceGroupList), true
+	}
+
+	instances, updatedAt, ok := snapshot.load()
+	if !ok {
+		return nil, false
+	}
+
+	filtered := filterScope(instances, accountList, regionList, resourceGroupList)
+	log.Printf("✅ Serving %d instances from discovery snapshot taken at %s", len(filtered), updatedAt.Format(time.RFC3339))
+	return filtered, true
+}
+
+// inResourceGroups reports whether an instance belongs to one of the resource groups, given by name or ID
+func inResourceGroups(resourceGroupList []string, instance Instance) bool {
+	return contains(resourceGroupList, instance.ResourceGroup) || contains(resourceGroupList, instance.ResourceGroupID)
+}
+
+// filterScope keeps the snapshot instances discovered for the given accounts, regions and resource groups,
+// where the account configuration overrides the given regions and resource groups like in fetchAllInstances.
+// Instances outside of resource groups (classic infrastructure) are always kept.
+func filterScope(instances []Instance, accountList, regionList, resourceGroupList []string) []Instance {
+	type scope struct{ regions, resourceGroups []string }
+	scopes := make(map[string]scope)
+	for _, account := range accountList {
+		regions, resourceGroups := loadAccountConfig(account).scope(regionList, resourceGroupList)
+		scopes[account] = scope{regions, resourceGroups}
+	}
+
+	filtered := []Instance{}
+	for _, inst := range instances {
+		accountScope, found := scopes[inst.Account]
+		if !found || !regionSelected(accountScope.regions, inst.Region) {
+			continue
+		}
+		if inst.ResourceGroup != "" && !inResourceGroups(accountScope.resourceGroups, inst) {
+			continue
+		}
+		filtered = append(filtered, inst)
+	}
+	return filtered
+}
+
+// writeSnapshotUnavailable tells the client to retry once the first discovery cycle has finished
+func writeSnapshotUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "Discovery has not completed yet, retry later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// filterExpr is a parsed filter expression such as
+//
+//	status=running AND tag:env=prod AND name=~"^web-" AND NOT public_ip
+//
+// Comparisons are field=value, field!=value, field=~regex and field!~regex; a bare field matches when it is
+// not empty. tag:<key> compares the value of a key:value tag, or matches a plain tag when used bare.
+// Conditions combine with AND, OR, NOT and parentheses; AND binds tighter than OR.
+type filterExpr interface {
+	match(instance Instance) bool
+}
+
+// filterFields maps the field names of the filter language to instance values
+var filterFields = map[string]func(Instance) string{
+	"name":              func(i Instance) string { return i.Name },
+	"id":                func(i Instance) string { return i.ID },
+	"crn":               func(i Instance) string { return i.InstanceID },
+	"status":            func(i Instance) string { return i.Status },
+	"profile":           func(i Instance) string { return i.Profile },
+	"zone":              func(i Instance) string { return i.AvailabilityZone },
+	"region":            func(i Instance) string { return i.Region },
+	"account":           func(i Instance) string { return i.Account },
+	"resource_group":    func(i Instance) string { return i.ResourceGroup },
+	"resource_group_id": func(i Instance) string { return i.ResourceGroupID },
+	"resource_type":     func(i Instance) string { return i.ResourceType },
+	"private_ip":        func(i Instance) string { return i.PrivateIP },
+	"public_ip":         func(i Instance) string { return i.PublicIP },
+	"private_ipv6":      func(i Instance) string { return i.PrivateIPv6 },
+	"public_ipv6":       func(i Instance) string { return i.PublicIPv6 },
+	"hostname":          func(i Instance) string { return i.Hostname },
+	"workspace":         func(i Instance) string { return i.Workspace },
+	"cluster":           func(i Instance) string { return i.Cluster },
+}
+
+type andExpr struct{ left, right filterExpr }
+type orExpr struct{ left, right filterExpr }
+type notExpr struct{ expr filterExpr }
+
+func (e andExpr) match(instance Instance) bool {
+	return e.left.match(instance) && e.right.match(instance)
+}
+func (e orExpr) match(instance Instance) bool {
+	return e.left.match(instance) || e.right.match(instance)
+}
+func (e notExpr) match(instance Instance) bool { return !e.expr.match(instance) }
+
+// compareExpr compares a field or tag value, missing values compare as ""; op is empty for a presence check
+type compareExpr struct {
+	field string // Field name, or the key of a tag:<key> condition
+	tag   bool
+	op    string
+	value string
+	regex *regexp.Regexp
+}
+
+func (e compareExpr) match(instance Instance) bool {
+	value, present := e.lookup(instance)
+	switch e.op {
+	case "":
+		return present
+	case "=":
+		return value == e.value
+	case "!=":
+		return value != e.value
+	case "=~":
+		return e.regex.MatchString(value)
+	case "!~":
+		return !e.regex.MatchString(value)
+	}
+	return false
+}
+
+func (e compareExpr) lookup(instance Instance) (string, bool) {
+	if !e.tag {
+		value := filterFields[e.field](instance)
+		return value, value != ""
+	}
+
+	for _, tag := range instance.Tags {
+		key, value, isKeyValue := splitTag(tag)
+		if key != e.field {
+			continue
+		}
+		if isKeyValue || e.op == "" {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// filterToken is a lexical token of a filter expression
+type filterToken struct {
+	kind  string // "(", ")", "op", "word", "string" or "end"
+	text  string
+	start int
+}
+
+// lexFilter splits a filter expression into tokens
+func lexFilter(input string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, filterToken{kind: string(r), text: string(r), start: i})
+			i++
+		case r == '=' || r == '!':
+			start := i
+			i++
+			if i < len(runes) && (runes[i] == '~' || (r == '!' && runes[i] == '=')) {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at position %d, use != or !~", start)
+			}
+			tokens = append(tokens, filterToken{kind: "op", text: op, start: start})
+		case r == '"':
+			start := i
+			var value strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string starting at position %d", start)
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == '"' {
+					i++
+					break
+				}
+				value.WriteRune(runes[i])
+			}
+			tokens = append(tokens, filterToken{kind: "string", text: value.String(), start: start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()=!\"", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: "word", text: string(runes[start:i]), start: start})
+		}
+	}
+	return append(tokens, filterToken{kind: "end", start: len(runes)}), nil
+}
+
+// filterParser is a recursive descent parser over the tokens of a filter expression
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilter parses a filter expression; an empty expression returns nil, which matches everything
+func parseFilter(input string) (filterExpr, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, nil
+	}
+
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err == nil && p.peek().kind != "end" {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	return expr, nil
+}
+
+func (p *filterParser) peek() filterToken { return p.tokens[p.pos] }
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != "end" {
+		p.pos++
+	}
+	return token
+}
+
+func (p *filterParser) isKeyword(keyword string) bool {
+	token := p.peek()
+	return token.kind == "word" && strings.EqualFold(token.text, keyword)
+}
+
+func (p *filterParser) unexpected() error {
+	token := p.peek()
+	if token.kind == "end" {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %d", token.text, token.start)
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	if p.peek().kind == "(" {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != ")" {
+			return nil, p.unexpected()
+		}
+		p.next()
+		return expr, nil
+	}
+
+	token := p.peek()
+	if token.kind != "word" || p.isKeyword("AND") || p.isKeyword("OR") {
+		return nil, p.unexpected()
+	}
+	p.next()
+
+	compare := compareExpr{field: token.text}
+	if key, found := strings.CutPrefix(token.text, "tag:"); found {
+		if key == "" {
+			return nil, fmt.Errorf("missing tag name at position %d", token.start)
+		}
+		compare = compareExpr{field: key, tag: true}
+	} else if _, known := filterFields[token.text]; !known {
+		return nil, fmt.Errorf("unknown field %q at position %d", token.text, token.start)
+	}
+
+	if p.peek().kind != "op" {
+		return compare, nil // Presence check
+	}
+	compare.op = p.next().text
+
+	value := p.next()
+	if value.kind != "word" && value.kind != "string" {
+		return nil, fmt.Errorf("missing value after %s at position %d", compare.op, value.start)
+	}
+	compare.value = value.text
+
+	if compare.op == "=~" || compare.op == "!~" {
+		regex, err := regexp.Compile(compare.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q at position %d: %v", compare.value, value.start, err)
+		}
+		compare.regex = regex
+	}
+	return compare, nil
+}
+
+// getFilter parses the filter query parameter
+func getFilter(r *http.Request) (filterExpr, error) {
+	return parseFilter(r.URL.Query().Get("filter"))
+}
+
+// applyFilter returns the instances matching the expression; a nil expression keeps all of them
+func applyFilter(instances []Instance, expr filterExpr) []Instance {
+	if expr == nil {
+		return instances
+	}
+
+	filtered := []Instance{}
+	for _, instance := range instances {
+		if expr.match(instance) {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
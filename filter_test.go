@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseFilterMatches(t *testing.T) {
+	web := Instance{Name: "web-1", Status: "running", Region: "us-east", PublicIP: "169.1.2.3", Tags: []string{"env:prod", "frontend"}}
+	db := Instance{Name: "db-1", Status: "stopped", Region: "eu-de", Tags: []string{"env:dev"}}
+
+	tests := []struct {
+		name   string
+		filter string
+		web    bool
+		db     bool
+	}{
+		{"equals", "status=running", true, false},
+		{"not equals", "status!=running", false, true},
+		{"regex", `name=~"^web-"`, true, false},
+		{"negated regex", `name!~"^web-"`, false, true},
+		{"presence", "public_ip", true, false},
+		{"not presence", "NOT public_ip", false, true},
+		{"tag value", "tag:env=prod", true, false},
+		{"plain tag", "tag:frontend", true, false},
+		{"key:value tag present", "tag:env", true, true},
+		{"keywords are case insensitive", "status=running and region=us-east", true, false},
+		// AND binds tighter than OR: a OR (b AND c)
+		{"AND before OR", "status=stopped OR status=running AND region=eu-de", false, true},
+		{"parentheses", "(status=stopped OR status=running) AND region=us-east", true, false},
+		{"NOT binds tighter than AND", "NOT status=running AND region=eu-de", false, true},
+		{"double NOT", "NOT NOT status=running", true, false},
+		{"quoted value with spaces and operators", `name="web-1" OR name="a b=c (d)"`, true, false},
+		{"escaped quote", `name="web\"1"`, false, false},
+		{"missing field compares as empty", `public_ip=""`, false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := parseFilter(test.filter)
+			if err != nil {
+				t.Fatalf("parseFilter(%q): %v", test.filter, err)
+			}
+			if got := expr.match(web); got != test.web {
+				t.Errorf("match(web) = %t, want %t", got, test.web)
+			}
+			if got := expr.match(db); got != test.db {
+				t.Errorf("match(db) = %t, want %t", got, test.db)
+			}
+		})
+	}
+}
+
+func TestParseFilterEmpty(t *testing.T) {
+	for _, input := range []string{"", "   "} {
+		expr, err := parseFilter(input)
+		if expr != nil || err != nil {
+			t.Errorf("parseFilter(%q) = %v, %v, want nil, nil", input, expr, err)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{"colour=red", `unknown field "colour" at position 0`},
+		{"status=running AND", "unexpected end of expression"},
+		{"status=running AND OR region=us-east", `unexpected "OR" at position 19`},
+		{"(status=running", "unexpected end of expression"},
+		{"status=running)", `unexpected ")" at position 14`},
+		{"status=running region=us-east", `unexpected "region" at position 15`},
+		{"status=", "missing value after = at position 7"},
+		{"status!", "unexpected '!' at position 6, use != or !~"},
+		{`name="web`, "unterminated string starting at position 5"},
+		{`name=~"["`, `invalid regex "[" at position 6`},
+		{"tag:=prod", "missing tag name at position 0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			_, err := parseFilter(test.filter)
+			if err == nil {
+				t.Fatalf("parseFilter(%q) succeeded, want error %q", test.filter, test.err)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseFilter(%q) error = %q, want it to contain %q", test.filter, err, test.err)
+			}
+		})
+	}
+}
+
+func TestInstancesRouteAppliesFilter(t *testing.T) {
+	daemonMode = true
+	defer func() { daemonMode = false }()
+	snapshot.store([]Instance{
+		{Name: "web-1", Account: "test-account", Region: "us-south", ResourceGroup: "default", Status: "running"},
+		{Name: "web-2", Account: "test-account", Region: "us-south", ResourceGroup: "default", Status: "stopped"},
+		{Name: "web-3", Account: "test-account", Region: "eu-de", ResourceGroup: "default", Status: "running"},
+	})
+	defer snapshot.store(nil)
+
+	handler := withQueryDefaults(instanceHandler, map[string]string{
+		"accounts":        "test-account",
+		"regions":         "us-south",
+		"resource_groups": "default",
+	})
+
+	tests := []struct {
+		query string
+		names []string
+	}{
+		{"", []string{"web-1", "web-2"}},
+		{"?filter=status%3Drunning", []string{"web-1"}},
+		{"?filter=status%3Drunning&regions=eu-de", []string{"web-3"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/instances"+test.query, nil))
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", recorder.Code, recorder.Body)
+			}
+
+			var instances []Instance
+			if err := json.NewDecoder(recorder.Body).Decode(&instances); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			names := []string{}
+			for _, instance := range instances {
+				names = append(names, instance.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(test.names, ",") {
+				t.Errorf("instances = %v, want %v", names, test.names)
+			}
+		})
+	}
+}
+
+func TestInstancesRouteRejectsInvalidFilter(t *testing.T) {
+	handler := withQueryDefaults(instanceHandler, map[string]string{"accounts": "test-account"})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/instances?filter=colour%3Dred", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", recorder.Code)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/IBM/platform-services-go-sdk/globalsearchv2"
+)
+
+const (
+	globalSearchPageLimit = 1000 // Maximum page size of the Global Search API
+	tagSearchBatchSize    = 50   // CRNs per search query, keeps the query well below the length limit
+)
+
+// searchResources pages through a Global Search query and calls handle for every result item
+func searchResources(apiKey, account, query string, fields []string, handle func(item globalsearchv2.ResultItem)) error {
+	searchService, err := clientsFor(account, apiKey).searchClient()
+	if err != nil {
+		return err
+	}
+
+	options := searchService.NewSearchOptions()
+	options.SetQuery(query)
+	options.SetFields(fields)
+	options.SetLimit(globalSearchPageLimit)
+
+	for {
+		result, _, err := retryCall("global_search", "Search", searchService.Search, options)
+		if err != nil {
+			return fmt.Errorf("search failed: %v", err)
+		}
+
+		for _, item := range result.Items {
+			handle(item)
+		}
+
+		// A short page is the last one; the cursor is returned on every page
+		if result.SearchCursor == nil || len(result.Items) < globalSearchPageLimit {
+			return nil
+		}
+		options.SetSearchCursor(*result.SearchCursor)
+	}
+}
+
+// fetchTagsByCRN looks up the tags of many resources with a few Global Search queries. Resources missing from
+// the result, e.g. because the search index has not caught up yet, are not in the returned map.
+func fetchTagsByCRN(apiKey, account string, crns []string) (map[string][]string, error) {
+	tagsByCRN := make(map[string][]string, len(crns))
+	for start := 0; start < len(crns); start += tagSearchBatchSize {
+		end := min(start+tagSearchBatchSize, len(crns))
+
+		terms := make([]string, 0, end-start)
+		for _, crn := range crns[start:end] {
+			terms = append(terms, fmt.Sprintf("crn:%q", crn))
+		}
+
+		err := searchResources(apiKey, account, strings.Join(terms, " OR "), []string{"crn", "tags"}, func(item globalsearchv2.ResultItem) {
+			if item.CRN != nil {
+				tagsByCRN[*item.CRN] = searchItemTags(item)
+			}
+		})
+		if err != nil {
+			return tagsByCRN, err
+		}
+	}
+	return tagsByCRN, nil
+}
+
+// searchItemTags returns the tags field of a search result item
+func searchItemTags(item globalsearchv2.ResultItem) []string {
+	tags := []string{}
+	if values, ok := item.GetProperty("tags").([]interface{}); ok {
+		for _, value := range values {
+			if tag, ok := value.(string); ok {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// resourceTags returns the tags of the given resources, fetched in bulk through Global Search. Only resources
+// the search did not return are looked up one by one through the tagging API.
+func resourceTags(apiKey, account string, crns []string) map[string][]string {
+	if len(crns) == 0 {
+		return map[string][]string{}
+	}
+
+	tagsByCRN, err := fetchTagsByCRN(apiKey, account, crns)
+	if err != nil {
+		log.Printf("⚠️ Warning: Bulk tag search failed for account %s, falling back to per-resource lookups: %v", maskAccount(account), err)
+	}
+
+	misses := 0
+	for _, crn := range crns {
+		if _, found := tagsByCRN[crn]; found {
+			continue
+		}
+		misses++
+		tags, err := fetchInstanceTags(apiKey, account, crn)
+		if err != nil {
+			log.Printf("⚠️ Warning: %v", err)
+			continue
+		}
+		tagsByCRN[crn] = tags
+	}
+
+	log.Printf("🏷️ Fetched tags of %d resources for account %s (%d looked up individually)", len(crns), maskAccount(account), misses)
+	return tagsByCRN
+}
+
+// attachTags sets the tags of instances identified by their CRN
+func attachTags(apiKey, account string, instances []Instance) {
+	crns := []string{}
+	seen := make(map[string]bool)
+	for _, instance := range instances {
+		if strings.HasPrefix(instance.InstanceID, "crn:") && !seen[instance.InstanceID] {
+			seen[instance.InstanceID] = true
+			crns = append(crns, instance.InstanceID)
+		}
+	}
+
+	tagsByCRN := resourceTags(apiKey, account, crns)
+	for i := range instances {
+		if tags, found := tagsByCRN[instances[i].InstanceID]; found {
+			instances[i].Tags = tags
+		}
+	}
+}
+
+// Discovery strategies for VPC virtual server instances
+const (
+	strategyRegional     = "regional"      // ListInstances per region and resource group
+	strategyGlobalSearch = "global_search" // Inventory from Global Search, VPC API only for details
+	strategyCompare      = "compare"       // Serve regional results and log how global_search differs
+)
+
+// discoveryStrategy is set with --discovery-strategy or discovery_strategy in config.json
+var discoveryStrategy = strategyRegional
+
+// validDiscoveryStrategy reports whether a discovery strategy name is known
+func validDiscoveryStrategy(strategy string) bool {
+	return strategy == strategyRegional || strategy == strategyGlobalSearch || strategy == strategyCompare
+}
+
+// fetchGlobalSearchInstances finds the VPC virtual server instances of an account with a few paginated Global
+// Search queries, which also return tags and resource group. Addresses, status, zone and profile come from one
+// paginated ListInstances per region that has instances. Bare metal servers, and load balancers when that
+// discoverer is enabled, are listed through the VPC API in the regions where the search found any.
+func fetchGlobalSearchInstances(apiKey, account string, regions, resourceGroups []string, workerPool chan struct{}) ([]Instance, error) {
+	resourceGroupNames := make(map[string]string) // ID -> name
+	groups := []resourceGroup{}
+	groupTerms := []string{}
+	for _, nameOrID := range resourceGroups {
+		resourceGroup, err := resolveResourceGroup(apiKey, account, nameOrID)
+		if err != nil {
+			log.Printf("⚠️ Warning: Could not resolve resource group '%s' for global search: %v", nameOrID, err)
+			continue
+		}
+		if _, found := resourceGroupNames[resourceGroup.ID]; found {
+			continue // Given by both name and ID
+		}
+		resourceGroupNames[resourceGroup.ID] = resourceGroup.Name
+		groups = append(groups, resourceGroup)
+		groupTerms = append(groupTerms, fmt.Sprintf("resource_group_id:%q", resourceGroup.ID))
+	}
+	if len(groupTerms) == 0 {
+		return nil, fmt.Errorf("none of the resource groups %v could be resolved", resourceGroups)
+	}
+
+	typeTerms := []string{"type:instance", "type:bare-metal-server"}
+	if contains(enabledDiscoverers, discovererLoadBalancer) {
+		typeTerms = append(typeTerms, "type:load-balancer")
+	}
+	query := "family:is AND (" + strings.Join(typeTerms, " OR ") + ") AND (" + strings.Join(groupTerms, " OR ") + ")"
+	if !contains(regions, regionsAll) {
+		regionTerms := make([]string, 0, len(regions))
+		for _, region := range regions {
+			regionTerms = append(regionTerms, fmt.Sprintf("region:%q", region))
+		}
+		query += " AND (" + strings.Join(regionTerms, " OR ") + ")"
+	}
+
+	type searchHit struct {
+		name, region, resourceGroupID string
+		tags                          []string
+	}
+	hits := make(map[string]searchHit) // CRN -> hit
+	hitsByRegion := make(map[string][]string)
+	otherRegions := make(map[string]bool) // Regions with bare metal servers or load balancers
+	others := 0
+
+	workerPool <- struct{}{}
+	err := searchResources(apiKey, account, query, []string{"crn", "name", "type", "region", "resource_group_id", "tags"}, func(item globalsearchv2.ResultItem) {
+		if item.CRN == nil {
+			return
+		}
+		region, _ := item.GetProperty("region").(string)
+		if resourceType, _ := item.GetProperty("type").(string); resourceType != "instance" {
+			otherRegions[region] = true
+			others++
+			return
+		}
+		name, _ := item.GetProperty("name").(string)
+		resourceGroupID, _ := item.GetProperty("resource_group_id").(string)
+		hits[*item.CRN] = searchHit{name: name, region: region, resourceGroupID: resourceGroupID, tags: searchItemTags(item)}
+		hitsByRegion[region] = append(hitsByRegion[region], *item.CRN)
+	})
+	<-workerPool
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🔎 Global search found %d instances in %d regions and %d bare metal servers or load balancers in %d regions for account %s",
+		len(hits), len(hitsByRegion), others, len(otherRegions), maskAccount(account))
+
+	var instances []Instance
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for region := range otherRegions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			workerPool <- struct{}{}
+			defer func() { <-workerPool }()
+
+			regionResources := fetchBareMetalAndLoadBalancers(apiKey, account, region, groups)
+			mu.Lock()
+			instances = append(instances, regionResources...)
+			mu.Unlock()
+		}(region)
+	}
+	for region, crns := range hitsByRegion {
+		wg.Add(1)
+		go func(region string, crns []string) {
+			defer wg.Done()
+			workerPool <- struct{}{}
+			defer func() { <-workerPool }()
+
+			details, err := fetchInstanceIPs(apiKey, account, region)
+			if err != nil {
+				log.Printf("⚠️ Error fetching instance details for region %s: %v", region, err)
+				return
+			}
+			detailsByCRN := make(map[string]Instance, len(details))
+			for _, detail := range details {
+				detailsByCRN[detail.InstanceID] = detail
+			}
+
+			regionInstances := []Instance{}
+			for _, crn := range crns {
+				instance, found := detailsByCRN[crn]
+				if !found {
+					// The search index lags behind deletions
+					log.Printf("⚠️ Instance %s from global search not found in the VPC API of %s, skipping it", hits[crn].name, region)
+					continue
+				}
+				instance.Account = account
+				instance.Tags = hits[crn].tags
+				instance.ResourceGroupID = hits[crn].resourceGroupID
+				instance.ResourceGroup = resourceGroupNames[hits[crn].resourceGroupID]
+				regionInstances = append(regionInstances, instance)
+			}
+
+			mu.Lock()
+			instances = append(instances, regionInstances...)
+			mu.Unlock()
+		}(region, crns)
+	}
+	wg.Wait()
+
+	return instances, nil
+}
+
+// fetchBareMetalAndLoadBalancers lists the bare metal servers of the resource groups in a region, and their load
+// balancers when that discoverer is enabled, like the regional discovery does next to the instances
+func fetchBareMetalAndLoadBalancers(apiKey, account, region string, groups []resourceGroup) []Instance {
+	vpcService, _, err := clientsFor(account, apiKey).vpcClient(region)
+	if err != nil {
+		log.Printf("⚠️ Error creating VPC client for region %s: %v", region, err)
+		return nil
+	}
+
+	floatingIPMap, err := fetchFloatingIPs(vpcService, region)
+	if err != nil {
+		log.Printf("⚠️ Warning: Could not fetch floating IPs for region '%s': %v", region, err)
+	}
+	vniAddresses, err := fetchVirtualNetworkInterfaceIPs(vpcService, region)
+	if err != nil {
+		log.Printf("⚠️ Warning: Could not fetch virtual network interface addresses for region '%s': %v", region, err)
+	}
+
+	resources := []Instance{}
+	for _, group := range groups {
+		groupResources, err := fetchBareMetalServers(vpcService, region, account, group.ID, floatingIPMap, vniAddresses)
+		if err != nil {
+			log.Printf("⚠️ Warning: Could not fetch bare metal servers for region '%s' and resource group '%s': %v", region, group.Name, err)
+		}
+
+		if contains(enabledDiscoverers, discovererLoadBalancer) {
+			loadBalancers, err := fetchLoadBalancers(vpcService, region, account, group.ID)
+			if err != nil {
+				log.Printf("⚠️ Warning: Could not fetch load balancers for region '%s' and resource group '%s': %v", region, group.Name, err)
+			}
+			groupResources = append(groupResources, loadBalancers...)
+		}
+
+		for i := range groupResources {
+			groupResources[i].ResourceGroup = group.Name
+			groupResources[i].ResourceGroupID = group.ID
+		}
+		resources = append(resources, groupResources...)
+	}
+
+	attachTags(apiKey, account, resources)
+	return resources
+}
+
+// compareDiscoveryStrategies runs the regional and the global search discovery, logs where they disagree and
+// returns the regional result
+func compareDiscoveryStrategies(apiKey, account string, regions, resourceGroups []string, workerPool chan struct{}) []Instance {
+	regional := fetchRegionalInstances(apiKey, account, regions, resourceGroups, workerPool)
+	searched, err := fetchGlobalSearchInstances(apiKey, account, regions, resourceGroups, workerPool)
+	if err != nil {
+		log.Printf("⚠️ Compare: global search discovery failed for account %s: %v", maskAccount(account), err)
+		return regional
+	}
+
+	searchedByCRN := make(map[string]Instance, len(searched))
+	for _, instance := range searched {
+		searchedByCRN[instance.InstanceID] = instance
+	}
+
+	differences, compared := 0, 0
+	for _, instance := range regional {
+		compared++
+		other, found := searchedByCRN[instance.InstanceID]
+		if !found {
+			log.Printf("🔀 Compare: %s %s (%s) only found by regional discovery", instance.ResourceType, instance.Name, instance.Region)
+			differences++
+			continue
+		}
+		delete(searchedByCRN, instance.InstanceID)
+
+		for _, field := range instanceDifferences(instance, other) {
+			log.Printf("🔀 Compare: instance %s differs in %s", instance.Name, field)
+			differences++
+		}
+	}
+	for _, instance := range searchedByCRN {
+		log.Printf("🔀 Compare: %s %s (%s) only found by global search discovery", instance.ResourceType, instance.Name, instance.Region)
+		differences++
+	}
+
+	log.Printf("🔀 Compare: %d differences between %d regional and %d global search instances for account %s",
+		differences, compared, len(searched), maskAccount(account))
+	return regional
+}
+
+// instanceDifferences lists the fields whose values the two strategies report differently
+func instanceDifferences(regional, searched Instance) []string {
+	fields := []string{}
+	compare := func(field, a, b string) {
+		if a != b {
+			fields = append(fields, fmt.Sprintf("%s (regional %q, global search %q)", field, a, b))
+		}
+	}
+	compare("name", regional.Name, searched.Name)
+	compare("region", regional.Region, searched.Region)
+	compare("status", regional.Status, searched.Status)
+	compare("private_ip", regional.PrivateIP, searched.PrivateIP)
+	compare("public_ip", regional.PublicIP, searched.PublicIP)
+	compare("resource_group", regional.ResourceGroup, searched.ResourceGroup)
+	compare("tags", strings.Join(sortedCopy(regional.Tags), ","), strings.Join(sortedCopy(searched.Tags), ","))
+	return fields
+}
+
+// sortedCopy returns a sorted copy of a list without modifying it
+func sortedCopy(list []string) []string {
+	sorted := append([]string(nil), list...)
+	sort.Strings(sorted)
+	return sorted
+}
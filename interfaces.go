@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+)
+
+const interfacePrimary = "primary"
+
+// NetworkInterface is one network interface of an instance with its addresses
+type NetworkInterface struct {
+	Name       string `json:"name"`
+	ID         string `json:"id"`
+	Subnet     string `json:"subnet"`
+	PrimaryIP  string `json:"primary_ip"`
+	IPv6       string `json:"ipv6,omitempty"` // First IPv6 address of the interface on dual-stack subnets
+	FloatingIP string `json:"floating_ip,omitempty"`
+	Primary    bool   `json:"primary"`
+}
+
+// addAddress files an address under PrimaryIP or IPv6 depending on its family, keeping the first of each
+func (iface *NetworkInterface) addAddress(address string) {
+	switch {
+	case address == "":
+	case isIPv6(address):
+		if iface.IPv6 == "" {
+			iface.IPv6 = address
+		}
+	case iface.PrimaryIP == "":
+		iface.PrimaryIP = address
+	}
+}
+
+// isIPv6 reports whether address is an IPv6 address (IPv4-mapped addresses count as IPv4)
+func isIPv6(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() == nil
+}
+
+// fetchVirtualNetworkInterfaceIPs maps every virtual network interface of a region to all of its reserved IPs.
+// Attachments only reference the primary IP, the IPv6 address of a dual-stack interface is one of the others.
+func fetchVirtualNetworkInterfaceIPs(vpcService *vpcv1.VpcV1, region string) (map[string][]string, error) {
+	addresses := make(map[string][]string)
+	options := vpcService.NewListVirtualNetworkInterfacesOptions()
+
+	for {
+		result, response, err := retryCall("vpc", "ListVirtualNetworkInterfaces", vpcService.ListVirtualNetworkInterfaces, options)
+		if err != nil {
+			return addresses, fmt.Errorf("failed to list virtual network interfaces in %s: %v (HTTP %d)", region, err, statusCode(response))
+		}
+
+		for _, vni := range result.VirtualNetworkInterfaces {
+			if vni.ID == nil {
+				continue
+			}
+			for _, ip := range vni.Ips {
+				if ip.Address != nil {
+					addresses[*vni.ID] = append(addresses[*vni.ID], *ip.Address)
+				}
+			}
+		}
+
+		if result.Next == nil {
+			break
+		}
+		start := nextPageStart(result.Next.Href, region)
+		if start == "" {
+			break
+		}
+		options.SetStart(start)
+	}
+
+	log.Printf("✅ Fetched addresses of %d virtual network interfaces for region '%s'", len(addresses), region)
+	return addresses, nil
+}
+
+// vpcInstanceInterfaces lists every network interface of a VSI together with its floating IP.
+// Newer instances use network attachments with virtual network interfaces instead of network interfaces.
+func vpcInstanceInterfaces(instance vpcv1.Instance, floatingIPMap map[string]string, vniAddresses map[string][]string) []NetworkInterface {
+	if len(instance.NetworkAttachments) > 0 {
+		return vpcInstanceAttachments(instance, floatingIPMap, vniAddresses)
+	}
+
+	primaryID := ""
+	if instance.PrimaryNetworkInterface != nil {
+		primaryID = stringValue(instance.PrimaryNetworkInterface.ID)
+	}
+
+	interfaces := []NetworkInterface{}
+	for i, nic := range instance.NetworkInterfaces {
+		iface := NetworkInterface{
+			Name: stringValue(nic.Name),
+			ID:   stringValue(nic.ID),
+		}
+		if primaryID != "" {
+			iface.Primary = iface.ID == primaryID
+		} else {
+			iface.Primary = i == 0 // The first interface is the primary one
+		}
+		if nic.PrimaryIP != nil {
+			iface.addAddress(stringValue(nic.PrimaryIP.Address))
+		}
+		if nic.Subnet != nil {
+			iface.Subnet = stringValue(nic.Subnet.Name)
+		}
+		iface.FloatingIP = floatingIPMap[iface.ID]
+		interfaces = append(interfaces, iface)
+	}
+
+	return interfaces
+}
+
+// vpcInstanceAttachments lists the network attachments of a VSI
+func vpcInstanceAttachments(instance vpcv1.Instance, floatingIPMap map[string]string, vniAddresses map[string][]string) []NetworkInterface {
+	primaryID := ""
+	if instance.PrimaryNetworkAttachment != nil {
+		primaryID = stringValue(instance.PrimaryNetworkAttachment.ID)
+	}
+
+	interfaces := []NetworkInterface{}
+	for i, attachment := range instance.NetworkAttachments {
+		iface := NetworkInterface{
+			Name: stringValue(attachment.Name),
+			ID:   stringValue(attachment.ID),
+		}
+		if primaryID != "" {
+			iface.Primary = iface.ID == primaryID
+		} else {
+			iface.Primary = i == 0
+		}
+		if attachment.PrimaryIP != nil {
+			iface.addAddress(stringValue(attachment.PrimaryIP.Address))
+		}
+		addVNIAddresses(&iface, vniAddresses, attachment.VirtualNetworkInterface)
+		if attachment.Subnet != nil {
+			iface.Subnet = stringValue(attachment.Subnet.Name)
+		}
+		iface.FloatingIP = attachmentFloatingIP(floatingIPMap, attachment.VirtualNetworkInterface, attachment.PrimaryIP)
+		interfaces = append(interfaces, iface)
+	}
+
+	return interfaces
+}
+
+// addVNIAddresses adds the secondary reserved IPs of the virtual network interface behind an attachment
+func addVNIAddresses(iface *NetworkInterface, vniAddresses map[string][]string, vni *vpcv1.VirtualNetworkInterfaceReferenceAttachmentContext) {
+	if vni == nil {
+		return
+	}
+	for _, address := range vniAddresses[stringValue(vni.ID)] {
+		iface.addAddress(address)
+	}
+}
+
+// attachmentFloatingIP looks up the floating IP of a network attachment, which is bound to its virtual
+// network interface; the reserved IP is checked too as floating IPs can also be matched through it
+func attachmentFloatingIP(floatingIPMap map[string]string, vni *vpcv1.VirtualNetworkInterfaceReferenceAttachmentContext, primaryIP *vpcv1.ReservedIPReference) string {
+	if vni != nil {
+		if ip, found := floatingIPMap[stringValue(vni.ID)]; found {
+			return ip
+		}
+	}
+	if primaryIP != nil {
+		if ip, found := floatingIPMap[stringValue(primaryIP.ID)]; found {
+			return ip
+		}
+	}
+	return ""
+}
+
+// bareMetalServerInterfaces lists the network attachments of a bare metal server, or its legacy
+// network interfaces for servers created before attachments existed
+func bareMetalServerInterfaces(server vpcv1.BareMetalServer, floatingIPMap map[string]string, vniAddresses map[string][]string) []NetworkInterface {
+	interfaces := []NetworkInterface{}
+
+	if len(server.NetworkAttachments) > 0 {
+		primaryID := ""
+		if server.PrimaryNetworkAttachment != nil {
+			primaryID = stringValue(server.PrimaryNetworkAttachment.ID)
+		}
+
+		for _, attachment := range server.NetworkAttachments {
+			iface := NetworkInterface{
+				Name:    stringValue(attachment.Name),
+				ID:      stringValue(attachment.ID),
+				Primary: primaryID != "" && stringValue(attachment.ID) == primaryID,
+			}
+			if attachment.PrimaryIP != nil {
+				iface.addAddress(stringValue(attachment.PrimaryIP.Address))
+			}
+			addVNIAddresses(&iface, vniAddresses, attachment.VirtualNetworkInterface)
+			if attachment.Subnet != nil {
+				iface.Subnet = stringValue(attachment.Subnet.Name)
+			}
+			iface.FloatingIP = attachmentFloatingIP(floatingIPMap, attachment.VirtualNetworkInterface, attachment.PrimaryIP)
+			interfaces = append(interfaces, iface)
+		}
+		return interfaces
+	}
+
+	primaryID := ""
+	if server.PrimaryNetworkInterface != nil {
+		primaryID = stringValue(server.PrimaryNetworkInterface.ID)
+	}
+	for _, nic := range server.NetworkInterfaces {
+		iface := NetworkInterface{
+			Name:    stringValue(nic.Name),
+			ID:      stringValue(nic.ID),
+			Primary: primaryID != "" && stringValue(nic.ID) == primaryID,
+		}
+		if nic.PrimaryIP != nil {
+			iface.addAddress(stringValue(nic.PrimaryIP.Address))
+		}
+		if nic.Subnet != nil {
+			iface.Subnet = stringValue(nic.Subnet.Name)
+		}
+		iface.FloatingIP = floatingIPMap[iface.ID]
+		interfaces = append(interfaces, iface)
+	}
+
+	return interfaces
+}
+
+// primaryAddresses returns the private and floating IP of the primary interface
+func primaryAddresses(interfaces []NetworkInterface) (string, string) {
+	for _, iface := range interfaces {
+		if iface.Primary {
+			return iface.PrimaryIP, iface.FloatingIP
+		}
+	}
+	return "", ""
+}
+
+// primaryIPv6 returns the IPv6 address of the primary interface, if it is on a dual-stack subnet
+func primaryIPv6(interfaces []NetworkInterface) string {
+	for _, iface := range interfaces {
+		if iface.Primary {
+			return iface.IPv6
+		}
+	}
+	return ""
+}
+
+// targetOptions controls which address of an instance becomes its target
+type targetOptions struct {
+	Interface  string             // Interface selector, see selectInterface
+	PreferIPv6 bool               // Use the IPv6 address of the interface when it has one, else its IPv4 address
+	Port       string             // Port appended to targets; empty leaves the port to Prometheus (file_sd default)
+	Template   *template.Template // Renders the target instead of address:port, see parseTargetTemplate
+	States     []string           // Statuses that get a target; empty or "all" includes every status
+}
+
+// address returns the address of iface to target
+func (o targetOptions) address(iface NetworkInterface) string {
+	if o.PreferIPv6 && iface.IPv6 != "" {
+		return iface.IPv6
+	}
+	if iface.PrimaryIP == "" {
+		return iface.IPv6 // IPv6-only interface
+	}
+	return iface.PrimaryIP
+}
+
+// targetHost formats an address as a target without port; Prometheus requires IPv6 addresses in brackets
+func targetHost(address string) string {
+	if isIPv6(address) {
+		return "[" + address + "]"
+	}
+	return address
+}
+
+// selectInterface picks the interface to target: "primary", "subnet:<subnet name>" or an interface name.
+// Resources without interface details only have their primary addresses.
+func selectInterface(instance Instance, selector string) (NetworkInterface, bool) {
+	if selector == "" {
+		selector = interfacePrimary
+	}
+
+	if len(instance.Interfaces) == 0 {
+		primary := NetworkInterface{PrimaryIP: instance.PrivateIP, IPv6: instance.PrivateIPv6, FloatingIP: instance.PublicIP, Primary: true}
+		return primary, selector == interfacePrimary
+	}
+
+	for _, iface := range instance.Interfaces {
+		switch {
+		case selector == interfacePrimary:
+			if iface.Primary {
+				return iface, true
+			}
+		case strings.HasPrefix(selector, "subnet:"):
+			if iface.Subnet == strings.TrimPrefix(selector, "subnet:") {
+				return iface, true
+			}
+		case iface.Name == selector:
+			return iface, true
+		}
+	}
+
+	return NetworkInterface{}, false
+}
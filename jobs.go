@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+// scrapeJob is a named job from config.json: a filter over the shared discovery snapshot, how to build its
+// targets and where to write them. Empty filters match everything.
+type scrapeJob struct {
+	Name           string   `mapstructure:"-"`
+	Accounts       []string `mapstructure:"accounts"`
+	Regions        []string `mapstructure:"regions"`
+	ResourceGroups []string `mapstructure:"resource_groups"`
+	ResourceTypes  []string `mapstructure:"resource_types"`
+	Tags           []string `mapstructure:"tags"`     // Instances must carry every listed tag
+	Statuses       []string `mapstructure:"statuses"` // e.g. running
+	Port           string   `mapstructure:"port"`     // Port appended to targets, unless the instance declares prometheus_port tags
+	Interface      string   `mapstructure:"interface"`
+	PreferIPv6     bool     `mapstructure:"prefer_ipv6"`
+	OutputFile     string   `mapstructure:"output_file"` // file_sd output written after every discovery cycle (optional)
+	TargetTemplate string   `mapstructure:"target_template"`
+
+	template *template.Template
+}
+
+var scrapeJobs []scrapeJob // Jobs from config.json, sorted by name
+
+// loadScrapeJobs reads and validates the jobs object of config.json
+func loadScrapeJobs() ([]scrapeJob, error) {
+	configured := make(map[string]scrapeJob)
+	if err := viper.UnmarshalKey("jobs", &configured); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs: %v", err)
+	}
+
+	jobs := []scrapeJob{}
+	for name, job := range configured {
+		if job.Port != "" {
+			if _, err := strconv.ParseUint(job.Port, 10, 16); err != nil {
+				return nil, fmt.Errorf("job %s has an invalid port %q", name, job.Port)
+			}
+		}
+		if job.TargetTemplate != "" {
+			tmpl, err := parseTargetTemplate(job.TargetTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("job %s: %v", name, err)
+			}
+			job.template = tmpl
+		}
+		job.Name = name
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
+
+// findScrapeJob returns the configured job with the given name
+func findScrapeJob(name string) (scrapeJob, bool) {
+	for _, job := range scrapeJobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return scrapeJob{}, false
+}
+
+// matches reports whether an instance passes every filter of the job
+func (j scrapeJob) matches(instance Instance) bool {
+	if len(j.Accounts) > 0 && !contains(j.Accounts, instance.Account) {
+		return false
+	}
+	if len(j.Regions) > 0 && !regionSelected(j.Regions, instance.Region) {
+		return false
+	}
+	if len(j.ResourceGroups) > 0 && !inResourceGroups(j.ResourceGroups, instance) {
+		return false
+	}
+	if len(j.ResourceTypes) > 0 && !contains(j.ResourceTypes, instance.ResourceType) {
+		return false
+	}
+	if len(j.Statuses) > 0 && !contains(j.Statuses, instance.Status) {
+		return false
+	}
+	for _, tag := range j.Tags {
+		if !contains(instance.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the instances of the job
+func (j scrapeJob) filter(instances []Instance) []Instance {
+	filtered := []Instance{}
+	for _, instance := range instances {
+		if j.matches(instance) {
+			filtered = append(filtered, instance)
+		}
+	}
+	return filtered
+}
+
+// targetOptions returns the target selection of the job, falling back to the global configuration
+func (j scrapeJob) targetOptions() targetOptions {
+	options := defaultTargetOptions()
+	if j.Interface != "" {
+		options.Interface = j.Interface
+	}
+	if j.PreferIPv6 {
+		options.PreferIPv6 = true
+	}
+	if j.template != nil {
+		options.Template = j.template
+	}
+	if len(j.Statuses) > 0 {
+		options.States = j.Statuses // The job's states replace target_states, e.g. a job for stopped instances
+	}
+	options.Port = j.Port
+	return options
+}
+
+// requestedJob resolves the job query parameter; the boolean is false when no job was requested
+func requestedJob(r *http.Request) (scrapeJob, bool, error) {
+	name := r.URL.Query().Get("job")
+	if name == "" {
+		return scrapeJob{}, false, nil
+	}
+
+	job, found := findScrapeJob(name)
+	if !found {
+		return scrapeJob{}, true, fmt.Errorf("unknown job %q", name)
+	}
+	return job, true, nil
+}
+
+// jobInstances serves a job from the discovery snapshot, which always covers every configured job
+func jobInstances(job scrapeJob) ([]Instance, bool) {
+	instances, _, ok := snapshot.load()
+	if !ok {
+		return nil, false
+	}
+	return job.filter(instances), true
+}
+
+// writeJobOutputs writes the file_sd output of every job that has one
+func writeJobOutputs(instances []Instance) {
+	for _, job := range scrapeJobs {
+		if job.OutputFile == "" {
+			continue
+		}
+
+		targetGroups := buildPrometheusTargetGroups(job.filter(instances), job.targetOptions())
+		targetGroups = applyRelabelConfigs(targetGroups, fileSDRelabelConfigs)
+		if err := writeSDConfig(job.OutputFile, targetGroups, sdBackups); err != nil {
+			log.Printf("❌ Error writing file for job %s to %s: %v", job.Name, job.OutputFile, err)
+			continue
+		}
+		log.Printf("✅ Job %s: %d target groups written to %s", job.Name, len(targetGroups), job.OutputFile)
+	}
+}
+
+// jobDiscoveryScope extends the accounts, regions and resource groups discovered each cycle with those
+// referenced by jobs, so a single discovery pass serves every job
+func jobDiscoveryScope(accounts, regions, resourceGroups []string) ([]string, []string, []string) {
+	accounts = append([]string(nil), accounts...)
+	regions = append([]string(nil), regions...)
+	resourceGroups = append([]string(nil), resourceGroups...)
+	for _, job := range scrapeJobs {
+		accounts = appendMissing(accounts, job.Accounts)
+		regions = appendMissing(regions, job.Regions)
+		resourceGroups = appendMissing(resourceGroups, job.ResourceGroups)
+	}
+	return accounts, regions, resourceGroups
+}
+
+// appendMissing appends the items of extra that are not in list yet
+func appendMissing(list, extra []string) []string {
+	for _, item := range extra {
+		if !contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+)
+
+const (
+	resourceTypeKubernetesWorker = "kubernetes_worker"
+
+	containersAPIURL = "https://containers.cloud.ibm.com/global"
+)
+
+// kubernetesCluster is the subset of the IKS/ROKS getClusters response used for discovery
+type kubernetesCluster struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	CRN               string `json:"crn"`
+	Provider          string `json:"provider"` // vpc-gen2, classic or satellite
+	Type              string `json:"type"`     // kubernetes or openshift
+	Region            string `json:"region"`
+	ResourceGroup     string `json:"resourceGroup"`
+	ResourceGroupName string `json:"resourceGroupName"`
+	State             string `json:"state"`
+}
+
+// kubernetesWorker covers both the VPC and the classic getWorkers responses
+type kubernetesWorker struct {
+	ID        string `json:"id"`
+	Flavor    string `json:"flavor"`
+	Location  string `json:"location"`
+	PoolID    string `json:"poolID"`
+	PoolName  string `json:"poolName"`
+	Lifecycle struct {
+		ActualState string `json:"actualState"`
+	} `json:"lifecycle"`
+	Health struct {
+		State string `json:"state"`
+	} `json:"health"`
+	NetworkInterfaces []struct {
+		SubnetID  string `json:"subnetID"`
+		IPAddress string `json:"ipAddress"`
+		Primary   bool   `json:"primary"`
+	} `json:"networkInterfaces"` // VPC workers
+	NetworkInformation struct {
+		PrivateIP string `json:"privateIP"`
+		PublicIP  string `json:"publicIP"`
+	} `json:"networkInformation"` // Classic workers
+}
+
+// fetchKubernetesWorkers discovers the worker nodes of all IKS and ROKS clusters in the requested resource groups
+func fetchKubernetesWorkers(apiKey, account string, resourceGroups []string) ([]Instance, error) {
+	authenticator := clientsFor(account, apiKey).authenticator
+	baseURL := endpoint(account, "containers", "")
+
+	var clusters []kubernetesCluster
+	if err := getIBMCloudJSON(authenticator, "containers", baseURL+"/v2/getClusters", nil, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %v", err)
+	}
+
+	selected := []kubernetesCluster{}
+	clusterCRNs := []string{}
+	for _, cluster := range clusters {
+		// Resource groups may be given by name or ID
+		if !contains(resourceGroups, cluster.ResourceGroupName) && !contains(resourceGroups, cluster.ResourceGroup) {
+			continue
+		}
+		selected = append(selected, cluster)
+		if cluster.CRN != "" {
+			clusterCRNs = append(clusterCRNs, cluster.CRN)
+		}
+	}
+
+	// Workers are not tagged themselves, so they inherit the cluster tags
+	clusterTags := resourceTags(apiKey, account, clusterCRNs)
+
+	instances := []Instance{}
+	for _, cluster := range selected {
+		workers, err := fetchClusterWorkers(authenticator, baseURL, cluster)
+		if err != nil {
+			log.Printf("⚠️ Error fetching workers for cluster '%s': %v", cluster.Name, err)
+			continue
+		}
+
+		tags := clusterTags[cluster.CRN]
+		for _, worker := range workers {
+			privateIP, publicIP := worker.NetworkInformation.PrivateIP, worker.NetworkInformation.PublicIP
+			interfaces := []NetworkInterface{}
+			hasPrimary := false
+			for _, nic := range worker.NetworkInterfaces {
+				iface := NetworkInterface{
+					ID:      nic.SubnetID,
+					Subnet:  nic.SubnetID,
+					Primary: nic.Primary,
+				}
+				iface.addAddress(nic.IPAddress)
+				interfaces = append(interfaces, iface)
+				if nic.Primary {
+					hasPrimary = true
+					if iface.PrimaryIP != "" {
+						privateIP = iface.PrimaryIP
+					}
+				}
+			}
+			// Workers may flag no interface as primary; like for VSIs, the first interface is then the primary one
+			if !hasPrimary && len(interfaces) > 0 {
+				interfaces[0].Primary = true
+				if privateIP == "" {
+					privateIP = interfaces[0].PrimaryIP
+				}
+			}
+
+			status := worker.Lifecycle.ActualState
+			if status == "deployed" {
+				status = "running"
+			}
+
+			instances = append(instances, Instance{
+				Name:             worker.ID,
+				ID:               worker.ID,
+				Region:           cluster.Region,
+				Account:          account,
+				PrivateIP:        privateIP,
+				PublicIP:         publicIP,
+				PrivateIPv6:      primaryIPv6(interfaces),
+				Status:           status,
+				AvailabilityZone: worker.Location,
+				Profile:          worker.Flavor,
+				Tags:             tags,
+				ResourceGroup:    cluster.ResourceGroupName,
+				ResourceGroupID:  cluster.ResourceGroup,
+				ResourceType:     resourceTypeKubernetesWorker,
+				Cluster:          cluster.Name,
+				ClusterID:        cluster.ID,
+				ClusterType:      cluster.Type,
+				WorkerPool:       worker.PoolName,
+				Interfaces:       interfaces,
+			})
+		}
+	}
+
+	log.Printf("✅ Fetched %d Kubernetes workers from %d clusters for account %s", len(instances), len(clusters), maskAccount(account))
+	return instances, nil
+}
+
+// fetchClusterWorkers calls the provider specific getWorkers API of a cluster
+func fetchClusterWorkers(authenticator *core.IamAuthenticator, baseURL string, cluster kubernetesCluster) ([]kubernetesWorker, error) {
+	var path string
+	switch cluster.Provider {
+	case "vpc-gen2":
+		path = "/v2/vpc/getWorkers"
+	case "classic":
+		path = "/v2/classic/getWorkers"
+	default:
+		return nil, fmt.Errorf("unsupported cluster provider %s", cluster.Provider)
+	}
+
+	requestURL := baseURL + path + "?cluster=" + url.QueryEscape(cluster.ID)
+	var workers []kubernetesWorker
+	if err := getIBMCloudJSON(authenticator, "containers", requestURL, nil, &workers); err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/IBM/vpc-go-sdk/vpcv1"
+)
+
+const (
+	resourceTypeLoadBalancer = "load_balancer"
+	discovererLoadBalancer   = "loadbalancer"
+
+	targetModeInstance = "instance" // One target per instance (default)
+	targetModeListener = "listener" // One target per load balancer listener URL, for blackbox_exporter
+)
+
+// Listener is a load balancer listener together with the members of its default pool
+type Listener struct {
+	Port     int64        `json:"port"`
+	Protocol string       `json:"protocol"`
+	Pool     string       `json:"pool,omitempty"`
+	Members  []PoolMember `json:"members,omitempty"`
+}
+
+// PoolMember is a backend of a load balancer pool
+type PoolMember struct {
+	Address string `json:"address"`
+	Port    int64  `json:"port"`
+	Health  string `json:"health"`
+}
+
+// fetchLoadBalancers lists the VPC load balancers of a region with their listeners and pool members
+func fetchLoadBalancers(vpcService *vpcv1.VpcV1, region, account, resourceGroupID string) ([]Instance, error) {
+	loadBalancers := []Instance{}
+	options := vpcService.NewListLoadBalancersOptions()
+
+	for {
+		result, response, err := retryCall("vpc", "ListLoadBalancers", vpcService.ListLoadBalancers, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list load balancers in %s: %v (HTTP %d)", region, err, statusCode(response))
+		}
+
+		for _, lb := range result.LoadBalancers {
+			// The load balancer API has no resource group filter
+			if resourceGroupID != "" && (lb.ResourceGroup == nil || stringValue(lb.ResourceGroup.ID) != resourceGroupID) {
+				continue
+			}
+
+			listeners, err := fetchLoadBalancerListeners(vpcService, *lb.ID)
+			if err != nil {
+				log.Printf("⚠️ Warning: Could not fetch listeners for load balancer '%s': %v", *lb.Name, err)
+			}
+
+			var privateIP, publicIP string
+			for _, ip := range lb.PrivateIps {
+				if ip.Address != nil {
+					privateIP = *ip.Address
+					break
+				}
+			}
+			for _, ip := range lb.PublicIps {
+				if ip.Address != nil {
+					publicIP = *ip.Address
+					break
+				}
+			}
+
+			status := stringValue(lb.OperatingStatus)
+			if status == "online" {
+				status = "running"
+			}
+
+			loadBalancers = append(loadBalancers, Instance{
+				Name:         *lb.Name,
+				ID:           *lb.ID,
+				Region:       region,
+				Account:      account,
+				PrivateIP:    privateIP,
+				PublicIP:     publicIP,
+				Status:       status,
+				InstanceID:   *lb.CRN,
+				ResourceType: resourceTypeLoadBalancer,
+				Hostname:     stringValue(lb.Hostname),
+				IsPublic:     lb.IsPublic != nil && *lb.IsPublic,
+				Listeners:    listeners,
+			})
+		}
+
+		if result.Next == nil {
+			break
+		}
+		start := nextPageStart(result.Next.Href, region)
+		if start == "" {
+			break
+		}
+		options.SetStart(start)
+	}
+
+	log.Printf("✅ Fetched %d load balancers for region '%s'", len(loadBalancers), region)
+	return loadBalancers, nil
+}
+
+// fetchLoadBalancerListeners returns the listeners of a load balancer with the health of their default pool members
+func fetchLoadBalancerListeners(vpcService *vpcv1.VpcV1, loadBalancerID string) ([]Listener, error) {
+	result, _, err := retryCall("vpc", "ListLoadBalancerListeners", vpcService.ListLoadBalancerListeners, vpcService.NewListLoadBalancerListenersOptions(loadBalancerID))
+	if err != nil {
+		return nil, err
+	}
+
+	poolMembers := make(map[string][]PoolMember) // Listeners often share a pool
+	listeners := []Listener{}
+	for _, l := range result.Listeners {
+		listener := Listener{Protocol: stringValue(l.Protocol)}
+		if l.Port != nil {
+			listener.Port = *l.Port
+		} else if l.PortMin != nil {
+			listener.Port = *l.PortMin // Port range listeners are probed on their first port
+		}
+
+		if l.DefaultPool != nil && l.DefaultPool.ID != nil {
+			listener.Pool = stringValue(l.DefaultPool.Name)
+			members, found := poolMembers[*l.DefaultPool.ID]
+			if !found {
+				members, err = fetchLoadBalancerPoolMembers(vpcService, loadBalancerID, *l.DefaultPool.ID)
+				if err != nil {
+					log.Printf("⚠️ Warning: Could not fetch members of pool '%s': %v", listener.Pool, err)
+				}
+				poolMembers[*l.DefaultPool.ID] = members
+			}
+			listener.Members = members
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+func fetchLoadBalancerPoolMembers(vpcService *vpcv1.VpcV1, loadBalancerID, poolID string) ([]PoolMember, error) {
+	result, _, err := retryCall("vpc", "ListLoadBalancerPoolMembers", vpcService.ListLoadBalancerPoolMembers, vpcService.NewListLoadBalancerPoolMembersOptions(loadBalancerID, poolID))
+	if err != nil {
+		return nil, err
+	}
+
+	members := []PoolMember{}
+	for _, m := range result.Members {
+		member := PoolMember{Health: stringValue(m.Health)}
+		if m.Port != nil {
+			member.Port = *m.Port
+		}
+
+		switch target := m.Target.(type) {
+		case *vpcv1.LoadBalancerPoolMemberTargetIP:
+			member.Address = stringValue(target.Address)
+		case *vpcv1.LoadBalancerPoolMemberTargetInstanceReference:
+			member.Address = stringValue(target.Name)
+		case *vpcv1.LoadBalancerPoolMemberTarget:
+			// Generic decoding: IP targets carry an address, instance targets a name
+			member.Address = stringValue(target.Address)
+			if member.Address == "" {
+				member.Address = stringValue(target.Name)
+			}
+		}
+
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// buildListenerTargetGroups emits one target per load balancer listener URL; labelPrefix is empty for
+// /prometheus and metaLabelPrefix for /http_sd
+func buildListenerTargetGroups(instances []Instance, labelPrefix string) []TargetGroup {
+	targetGroups := []TargetGroup{}
+	for _, lb := range instances {
+		if lb.ResourceType != resourceTypeLoadBalancer {
+			continue
+		}
+
+		host := lb.Hostname
+		if host == "" {
+			host = lb.PublicIP
+		}
+		if host == "" {
+			host = lb.PrivateIP
+		}
+		if host == "" {
+			log.Printf("⚠️ Load balancer %s has no hostname or IP, skipping listener targets", lb.Name)
+			continue
+		}
+
+		for _, listener := range lb.Listeners {
+			healthy := 0
+			for _, member := range listener.Members {
+				if member.Health == "ok" {
+					healthy++
+				}
+			}
+
+			targetGroups = append(targetGroups, TargetGroup{
+				Targets: []string{listenerURL(host, listener)},
+				Labels: map[string]string{
+					labelPrefix + "lb_name":              lb.Name,
+					labelPrefix + "lb_id":                lb.ID,
+					labelPrefix + "lb_hostname":          lb.Hostname,
+					labelPrefix + "lb_is_public":         strconv.FormatBool(lb.IsPublic),
+					labelPrefix + "listener_protocol":    listener.Protocol,
+					labelPrefix + "listener_port":        strconv.FormatInt(listener.Port, 10),
+					labelPrefix + "pool":                 listener.Pool,
+					labelPrefix + "pool_members_total":   strconv.Itoa(len(listener.Members)),
+					labelPrefix + "pool_members_healthy": strconv.Itoa(healthy),
+					labelPrefix + "region":               lb.Region,
+					labelPrefix + "account":              lb.Account,
+					labelPrefix + "status":               lb.Status,
+				},
+			})
+		}
+	}
+
+	return targetGroups
+}
+
+// listenerURL formats a listener as a probe target: a URL for HTTP(S), host:port for TCP/UDP
+func listenerURL(host string, listener Listener) string {
+	address := net.JoinHostPort(host, strconv.FormatInt(listener.Port, 10))
+	switch listener.Protocol {
+	case "http", "https":
+		return listener.Protocol + "://" + address
+	default:
+		return address
+	}
+}
@@ -1,57 +1,83 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/IBM/go-sdk-core/v5/core"
-	"github.com/IBM/platform-services-go-sdk/globaltaggingv1"
-	"github.com/IBM/platform-services-go-sdk/resourcemanagerv2"
 	"github.com/IBM/vpc-go-sdk/vpcv1"
 	"github.com/go-redis/redis/v8"
 	"github.com/hashicorp/vault/api"
 	"github.com/spf13/viper"
 )
 
-// Config structure
-type Config struct {
-	Accounts       map[string]string `json:"accounts"`
-	Port           string            `json:"port"`
-	Regions        map[string]string `json:"regions"`
-	ResourceGroups map[string]string `json:"resource_groups"` // Add ResourceGroups field
-	OutputSDFile   string            `json:"output_sd_file"`
-}
-
 // Instance struct
 type Instance struct {
-	Name             string   `json:"name"`
-	ID               string   `json:"id"`
-	Region           string   `json:"region"`
-	Account          string   `json:"account"`
-	PublicIP         string   `json:"public_ip"`
-	PrivateIP        string   `json:"private_ip"`
-	Status           string   `json:"status"`
-	AvailabilityZone string   `json:"availability_zone"`
-	InstanceID       string   `json:"instance_id"`
-	Profile          string   `json:"profile"`
-	Tags             []string `json:"tags"` // Add Tags field
+	Name             string     `json:"name"`
+	ID               string     `json:"id"`
+	Region           string     `json:"region"`
+	Account          string     `json:"account"`
+	PublicIP         string     `json:"public_ip"`
+	PrivateIP        string     `json:"private_ip"`
+	PublicIPv6       string     `json:"public_ipv6,omitempty"`  // Public IPv6 address (classic infrastructure)
+	PrivateIPv6      string     `json:"private_ipv6,omitempty"` // IPv6 address of the primary interface on dual-stack subnets
+	Status           string     `json:"status"`
+	AvailabilityZone string     `json:"availability_zone"`
+	InstanceID       string     `json:"instance_id"`
+	Profile          string     `json:"profile"`
+	Tags             []string   `json:"tags"`                        // Add Tags field
+	ResourceGroup    string     `json:"resource_group,omitempty"`    // Resource group the instance was discovered in
+	ResourceGroupID  string     `json:"resource_group_id,omitempty"` // ID of the resource group
+	ResourceType     string     `json:"resource_type"`               // instance, bare_metal_server, pvm_instance, classic_virtual_guest, classic_bare_metal, kubernetes_worker or load_balancer
+	Workspace        string     `json:"workspace,omitempty"`         // PowerVS workspace name
+	WorkspaceID      string     `json:"workspace_id,omitempty"`      // PowerVS workspace GUID
+	Hostname         string     `json:"hostname,omitempty"`          // Classic infrastructure hostname
+	Domain           string     `json:"domain,omitempty"`            // Classic infrastructure domain
+	Cluster          string     `json:"cluster,omitempty"`           // IKS/ROKS cluster name
+	ClusterID        string     `json:"cluster_id,omitempty"`        // IKS/ROKS cluster ID
+	ClusterType      string     `json:"cluster_type,omitempty"`      // kubernetes or openshift
+	WorkerPool       string     `json:"worker_pool,omitempty"`       // IKS/ROKS worker pool name
+	IsPublic         bool       `json:"is_public,omitempty"`         // Load balancer is public
+	Listeners        []Listener `json:"listeners,omitempty"`         // Load balancer listeners
+
+	Interfaces []NetworkInterface `json:"interfaces,omitempty"` // Every network interface; PrivateIP/PublicIP belong to the primary one
+	Labels     map[string]string  `json:"labels,omitempty"`     // Labels after http_relabel_configs, only set by /instances
+}
+
+// TargetGroup is a single entry of the Prometheus http_sd/file_sd JSON format
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
 }
 
+const (
+	metaLabelPrefix   = "__meta_ibmcloud_" // Prefix for discovery labels exposed to relabeling
+	defaultScrapePort = "9100"             // node_exporter default port
+)
+
 var (
 	ctx     = context.Background()
 	rdb     *redis.Client
 	expiry  = 5 * time.Minute // Cache expiry time
 	version string            // Version variable to be set by ldflags
+
+	sdBackups = 1 // Number of .bak generations kept when rewriting the file_sd output
+
+	ibmHTTPClient = &http.Client{Timeout: 60 * time.Second} // Client for IBM Cloud REST APIs without an SDK
 )
 
 func init() {
@@ -89,67 +115,104 @@ func getAPIKey(account string) (string, error) {
 		return envKey, nil
 	}
 
-	// 2️⃣ Check config file
-	viper.SetConfigName("config")
-	viper.SetConfigType("json")
-	viper.AddConfigPath(".")
-	if err := viper.ReadInConfig(); err == nil {
-		if key := viper.GetString("accounts." + account); key != "" {
-			return key, nil
-		}
+	// 2️⃣ Check config file: the account entry is the key itself or names where to find it
+	if key, found := loadAccountConfig(account).configuredAPIKey(account); found {
+		return key, nil
 	}
 
 	// 3️⃣ Check HashiCorp Vault
+	if key, err := readVaultAPIKey("secret/data/ibmcloud/" + account); err == nil {
+		return key, nil
+	}
+
+	return "", fmt.Errorf("API key for account %s not found", maskAccount(account))
+}
+
+// readVaultAPIKey reads the api_key field of a KV v2 secret from the local Vault
+func readVaultAPIKey(path string) (string, error) {
 	vaultConfig := &api.Config{Address: "http://127.0.0.1:8200"}
 	vaultClient, err := api.NewClient(vaultConfig)
-	if err == nil {
-		vaultClient.SetToken(os.Getenv("VAULT_TOKEN"))
-		secret, err := vaultClient.Logical().Read("secret/data/ibmcloud/" + account)
-		if err == nil && secret != nil {
-			if data, ok := secret.Data["data"].(map[string]interface{}); ok {
-				if key, exists := data["api_key"].(string); exists {
-					return key, nil
-				}
+	if err != nil {
+		return "", err
+	}
+	vaultClient.SetToken(os.Getenv("VAULT_TOKEN"))
+	secret, err := vaultClient.Logical().Read(path)
+	if err != nil {
+		return "", err
+	}
+	if secret != nil {
+		if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+			if key, exists := data["api_key"].(string); exists {
+				return key, nil
 			}
 		}
 	}
-
-	return "", fmt.Errorf("API key for account %s not found", maskAccount(account))
+	return "", fmt.Errorf("no api_key at %s", path)
 }
 
-func fetchAllInstances(account string, resourceGroups []string) ([]Instance, error) {
+// fetchAllInstances runs every enabled discoverer for an account; VPC regional endpoints are only contacted
+// for the requested regions, see resolveRegions. Regions, resource groups and tags configured for the account
+// take precedence over the requested ones.
+func fetchAllInstances(account string, regionList, resourceGroups []string) ([]Instance, error) {
 	apiKey, err := getAPIKey(account)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get API key: %v", err)
 	}
 
-	// Fetch all available IBM Cloud regions dynamically
-	regions, err := getAllRegions(apiKey)
-	if err != nil {
-		return nil, err
-	}
+	accountConfig := loadAccountConfig(account)
+	regionList, resourceGroups = accountConfig.scope(regionList, resourceGroups)
 
 	var allInstances []Instance
 	var wg sync.WaitGroup
 	instanceChan := make(chan []Instance)
 	workerPool := make(chan struct{}, 10) // Limit concurrency to 10 workers
 
-	for _, region := range regions {
-		for _, resourceGroup := range resourceGroups {
-			wg.Add(1)
-			workerPool <- struct{}{} // Acquire a worker slot
-			go func(region, resourceGroup string) {
-				defer wg.Done()
-				defer func() { <-workerPool }() // Release the worker slot
+	if contains(enabledDiscoverers, discovererVPC) {
+		regions, err := resolveRegions(apiKey, account, regionList)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("🌎 Scanning regions %v for account %s (%s discovery)", regions, maskAccount(account), discoveryStrategy)
 
-				instances, err := fetchInstancesForRegionAndResourceGroup(apiKey, region, account, resourceGroup)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch discoveryStrategy {
+			case strategyGlobalSearch:
+				instances, err := fetchGlobalSearchInstances(apiKey, account, regions, resourceGroups, workerPool)
 				if err != nil {
-					log.Printf("⚠️ Error fetching instances for region %s and resource group %s: %v", region, resourceGroup, err)
+					log.Printf("⚠️ Error running global search discovery for account %s: %v", maskAccount(account), err)
 					return
 				}
 				instanceChan <- instances
-			}(region, resourceGroup)
+			case strategyCompare:
+				instanceChan <- compareDiscoveryStrategies(apiKey, account, regions, resourceGroups, workerPool)
+			default:
+				instanceChan <- fetchRegionalInstances(apiKey, account, regions, resourceGroups, workerPool)
+			}
+		}()
+	}
+
+	// Account-wide discoverers for services outside the regional VPC endpoints share the same worker pool
+	for _, name := range enabledDiscoverers {
+		discover, found := accountDiscoverers[name]
+		if !found {
+			continue
 		}
+
+		wg.Add(1)
+		go func(name string, discover accountDiscoverer) {
+			defer wg.Done()
+			workerPool <- struct{}{}
+			defer func() { <-workerPool }()
+
+			instances, err := discover(apiKey, account, resourceGroups)
+			if err != nil {
+				log.Printf("⚠️ Error running %s discovery for account %s: %v", name, maskAccount(account), err)
+				return
+			}
+			instanceChan <- instances
+		}(name, discover)
 	}
 
 	// Collect results from goroutines
@@ -159,8 +222,14 @@ func fetchAllInstances(account string, resourceGroups []string) ([]Instance, err
 	}()
 
 	for instances := range instanceChan {
-		allInstances = append(allInstances, instances...)
+		for _, instance := range instances {
+			// Account-wide discoverers return every region
+			if regionSelected(regionList, instance.Region) {
+				allInstances = append(allInstances, instance)
+			}
+		}
 	}
+	allInstances = accountConfig.filterTags(allInstances)
 
 	// Cache results in Redis
 	cacheKey := fmt.Sprintf("instances:%s", account)
@@ -179,79 +248,60 @@ func fetchAllInstances(account string, resourceGroups []string) ([]Instance, err
 	return allInstances, nil
 }
 
-// Updated fetchInstances to dynamically fetch regions
-func fetchInstances(account string) ([]Instance, error) {
-	cacheKey := fmt.Sprintf("instances:%s", account)
-	cachedInstances, err := rdb.Get(ctx, cacheKey).Result()
-	if err == nil {
-		var instances []Instance
-		if err := json.Unmarshal([]byte(cachedInstances), &instances); err == nil {
-			log.Printf("✅ Retrieved instances for %s from Redis cache", account)
-			return instances, nil
-		}
-		log.Printf("⚠️ Error unmarshalling cached instances for %s: %v", account, err)
-	} else {
-		log.Printf("ℹ️ No cached instances found for %s, fetching from API", account)
-	}
-
-	apiKey, err := getAPIKey(account)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get API key: %v", err)
-	}
-
-	// Dynamically fetch regions instead of using a static list
-	regions, err := getAllRegions(apiKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch regions: %v", err)
-	}
-
+// fetchRegionalInstances lists the VPC resources of every region and resource group through the regional
+// VPC endpoints, one worker per region and resource group
+func fetchRegionalInstances(apiKey, account string, regions, resourceGroups []string, workerPool chan struct{}) []Instance {
 	var allInstances []Instance
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	instanceChan := make(chan []Instance)
 
 	for _, region := range regions {
-		wg.Add(1)
-		go func(region string) {
-			defer wg.Done()
+		for _, resourceGroup := range resourceGroups {
+			wg.Add(1)
+			go func(region, resourceGroup string) {
+				defer wg.Done()
+				workerPool <- struct{}{}        // Acquire a worker slot
+				defer func() { <-workerPool }() // Release the worker slot
 
-			instances, err := fetchInstancesForRegion(apiKey, region, account)
-			if err != nil {
-				log.Printf("⚠️ Error fetching instances for region %s: %v", region, err)
-				return
-			}
-			instanceChan <- instances
-		}(region)
+				instances, err := fetchInstancesForRegionAndResourceGroup(apiKey, region, account, resourceGroup)
+				if err != nil {
+					log.Printf("⚠️ Error fetching instances for region %s and resource group %s: %v", region, resourceGroup, err)
+					return
+				}
+				mu.Lock()
+				allInstances = append(allInstances, instances...)
+				mu.Unlock()
+			}(region, resourceGroup)
+		}
 	}
 
-	// Collect results from goroutines
-	go func() {
-		wg.Wait()
-		close(instanceChan)
-	}()
-
-	for instances := range instanceChan {
-		allInstances = append(allInstances, instances...)
-	}
+	wg.Wait()
+	return allInstances
+}
 
-	log.Printf("✅ Fetched %d instances for account %s", len(allInstances), maskAccount(account))
+// accountDiscoverer discovers resources for a whole account rather than per VPC region
+type accountDiscoverer func(apiKey, account string, resourceGroups []string) ([]Instance, error)
 
-	// Cache the instances in Redis
-	cacheInstancesInRedis(cacheKey, allInstances)
+const discovererVPC = "vpc"
 
-	return allInstances, nil
+// accountDiscoverers can be enabled next to the VPC discovery with the discoverers setting
+var accountDiscoverers = map[string]accountDiscoverer{
+	"powervs":    fetchPowerVSInstances,
+	"classic":    fetchClassicInstances,
+	"kubernetes": fetchKubernetesWorkers,
 }
 
-func getAllRegions(apiKey string) ([]string, error) {
-	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
-	vpcService, err := vpcv1.NewVpcV1(&vpcv1.VpcV1Options{Authenticator: authenticator})
+// enabledDiscoverers lists the discovery backends run for every account
+var enabledDiscoverers = []string{discovererVPC}
+
+func getAllRegions(apiKey, account string) ([]string, error) {
+	vpcService, _, err := clientsFor(account, apiKey).vpcClient("global") // Global endpoint
 	if err != nil {
-		return nil, fmt.Errorf("failed to create VPC service: %v", err)
+		return nil, err
 	}
 
-	vpcService.SetServiceURL("https://global.iaas.cloud.ibm.com/v1") // Global endpoint
-
 	options := vpcService.NewListRegionsOptions()
-	result, _, err := vpcService.ListRegions(options)
+	result, _, err := retryCall("vpc", "ListRegions", vpcService.ListRegions, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list regions: %v", err)
 	}
@@ -266,20 +316,17 @@ func getAllRegions(apiKey string) ([]string, error) {
 }
 
 // Update fetchInstanceTags to use globaltaggingv1
-func fetchInstanceTags(apiKey, resourceID string) ([]string, error) {
-	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
-	taggingService, err := globaltaggingv1.NewGlobalTaggingV1(&globaltaggingv1.GlobalTaggingV1Options{
-		Authenticator: authenticator,
-	})
+func fetchInstanceTags(apiKey, account, resourceID string) ([]string, error) {
+	taggingService, err := clientsFor(account, apiKey).taggingClient()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create tagging service: %v", err)
+		return nil, err
 	}
 
 	options := taggingService.NewListTagsOptions()
 	options.SetAttachedTo(resourceID)
 	options.SetLimit(100)
 
-	result, _, err := taggingService.ListTags(options)
+	result, _, err := retryCall("global_tagging", "ListTags", taggingService.ListTags, options)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch tags for resource %s: %v", resourceID, err)
 	}
@@ -292,331 +339,222 @@ func fetchInstanceTags(apiKey, resourceID string) ([]string, error) {
 	return tags, nil
 }
 
-// Update fetchInstancesForRegion to include tags
-func fetchInstancesForRegion(apiKey, region, account string) ([]Instance, error) {
-	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
-	vpcService, err := vpcv1.NewVpcV1(&vpcv1.VpcV1Options{Authenticator: authenticator})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create VPC service: %v", err)
-	}
-
-	vpcServiceURL := fmt.Sprintf("https://%s.iaas.cloud.ibm.com/v1", region)
-	vpcService.SetServiceURL(vpcServiceURL)
-	log.Printf("🔍 Fetching instances from %s", maskURL(vpcServiceURL))
-
-	// Fetch Floating IPs (for public IP mapping)
-	floatingIPMap, err := fetchFloatingIPs(vpcService)
-	if err != nil {
-		log.Printf("⚠️ Warning: Could not fetch floating IPs for %s: %v", region, err)
-	}
-
-	instances := []Instance{}
-	options := vpcService.NewListInstancesOptions()
-
-	for {
-		result, response, err := vpcService.ListInstances(options)
-		if err != nil {
-			return nil, fmt.Errorf("failed to list instances in %s: %v (HTTP %d)", region, err, response.StatusCode)
-		}
-
-		for _, instance := range result.Instances {
-			var privateIP, publicIP string
-			for _, iface := range instance.NetworkInterfaces {
-				if iface.PrimaryIP != nil {
-					privateIP = *iface.PrimaryIP.Address
-				}
-				if ip, found := floatingIPMap[*iface.ID]; found {
-					publicIP = ip
-				}
-			}
-
-			profile := ""
-			if instance.Profile != nil && instance.Profile.Name != nil {
-				profile = *instance.Profile.Name
-			}
-
-			// Fetch tags for the instance
-			tags, err := fetchInstanceTags(apiKey, *instance.CRN)
-			if err != nil {
-				log.Printf("⚠️ Warning: Could not fetch tags for instance %s: %v", *instance.Name, err)
-			}
-
-			instances = append(instances, Instance{
-				Name:             *instance.Name,
-				ID:               *instance.ID,
-				Region:           region,
-				Account:          account,
-				Status:           *instance.Status,
-				AvailabilityZone: *instance.Zone.Name,
-				InstanceID:       *instance.CRN,
-				PrivateIP:        privateIP,
-				PublicIP:         publicIP,
-				Profile:          profile,
-				Tags:             tags, // Add tags to the instance
-			})
-		}
-
-		// 🔥 Fix: Extract the 'start' parameter safely
-		if result.Next != nil && result.Next.Href != nil {
-			nextURL, err := url.Parse(*result.Next.Href)
-			if err != nil {
-				log.Printf("⚠️ Warning: Failed to parse Next URL for region %s: %v", region, err)
-				break
-			}
-
-			queryParams := nextURL.Query()
-			startParam := queryParams.Get("start")
-
-			if startParam == "" {
-				log.Printf("⚠️ Warning: 'start' parameter missing in Next URL for region %s", region)
-				break
-			}
-			// 🔍 Add log statement to track pagination
-			log.Printf("🔍 Next pagination token for region %s: %s", region, maskToken(startParam))
-			options.SetStart(startParam) // ✅ Use extracted pagination token
-		} else {
-			break
-		}
-	}
-
-	return instances, nil
-}
-
 func fetchInstancesForRegionAndResourceGroup(apiKey, region, account, resourceGroupName string) ([]Instance, error) {
 	log.Printf("🔍 Starting to fetch instances for region '%s' and resource group '%s'", region, resourceGroupName)
 
-	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
-	vpcService, err := vpcv1.NewVpcV1(&vpcv1.VpcV1Options{Authenticator: authenticator})
+	vpcService, vpcServiceURL, err := clientsFor(account, apiKey).vpcClient(region)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create VPC service: %v", err)
+		return nil, err
 	}
-
-	vpcServiceURL := fmt.Sprintf("https://%s.iaas.cloud.ibm.com/v1", region)
-	vpcService.SetServiceURL(vpcServiceURL)
 	log.Printf("🔍 Fetching instances from VPC service URL: %s", maskURL(vpcServiceURL))
 
-	// Fetch the resource group ID for the given resource group name
-	resourceGroupID, err := getResourceGroupID(apiKey, resourceGroupName)
+	// Resolve the resource group, given by name or ID, within the account
+	resourceGroup, err := resolveResourceGroup(apiKey, account, resourceGroupName)
 	if err != nil {
 		log.Printf("❌ Failed to fetch resource group ID for '%s': %v", resourceGroupName, err)
 		return nil, fmt.Errorf("failed to fetch resource group ID for %s: %v", resourceGroupName, err)
 	}
+	resourceGroupID := resourceGroup.ID
 	log.Printf("✅ Resource group '%s' resolved to ID '%s'", resourceGroupName, resourceGroupID)
 
 	// Fetch Floating IPs (for public IP mapping)
-	floatingIPMap, err := fetchFloatingIPs(vpcService)
+	floatingIPMap, err := fetchFloatingIPs(vpcService, region)
 	if err != nil {
 		log.Printf("⚠️ Warning: Could not fetch floating IPs for region '%s': %v", region, err)
 	}
 
+	// Secondary addresses of virtual network interfaces (IPv6 on dual-stack subnets)
+	vniAddresses, err := fetchVirtualNetworkInterfaceIPs(vpcService, region)
+	if err != nil {
+		log.Printf("⚠️ Warning: Could not fetch virtual network interface addresses for region '%s': %v", region, err)
+	}
+
 	instances := []Instance{}
 	options := vpcService.NewListInstancesOptions()
 	options.SetResourceGroupID(resourceGroupID) // Apply the resource group ID filter
 
 	for {
-		result, response, err := vpcService.ListInstances(options)
+		result, response, err := retryCall("vpc", "ListInstances", vpcService.ListInstances, options)
 		if err != nil {
-			log.Printf("❌ Error listing instances in region '%s' for resource group '%s': %v (HTTP %d)", region, resourceGroupName, err, response.StatusCode)
-			return nil, fmt.Errorf("failed to list instances in %s: %v (HTTP %d)", region, err, response.StatusCode)
+			log.Printf("❌ Error listing instances in region '%s' for resource group '%s': %v (HTTP %d)", region, resourceGroupName, err, statusCode(response))
+			return nil, fmt.Errorf("failed to list instances in %s: %v (HTTP %d)", region, err, statusCode(response))
 		}
 
 		for _, instance := range result.Instances {
-			var privateIP, publicIP string
-			for _, iface := range instance.NetworkInterfaces {
-				if iface.PrimaryIP != nil {
-					privateIP = *iface.PrimaryIP.Address
-				}
-				if ip, found := floatingIPMap[*iface.ID]; found {
-					publicIP = ip
-				}
-			}
-
-			profile := ""
-			if instance.Profile != nil && instance.Profile.Name != nil {
-				profile = *instance.Profile.Name
-			}
-
-			// Fetch tags for the instance
-			tags, err := fetchInstanceTags(apiKey, *instance.CRN)
-			if err != nil {
-				log.Printf("⚠️ Warning: Could not fetch tags for instance '%s': %v", *instance.Name, err)
-			}
-
-			instances = append(instances, Instance{
-				Name:             *instance.Name,
-				ID:               *instance.ID,
-				Region:           region,
-				Account:          account,
-				Status:           *instance.Status,
-				AvailabilityZone: *instance.Zone.Name,
-				InstanceID:       *instance.CRN,
-				PrivateIP:        privateIP,
-				PublicIP:         publicIP,
-				Profile:          profile,
-				Tags:             tags,
-			})
+			instances = append(instances, vpcInstance(instance, region, account, floatingIPMap, vniAddresses))
 		}
 
-		// Handle pagination
-		if result.Next != nil && result.Next.Href != nil {
-			nextURL, err := url.Parse(*result.Next.Href)
-			if err != nil {
-				log.Printf("⚠️ Warning: Failed to parse Next URL for region '%s': %v", region, err)
-				break
-			}
-
-			queryParams := nextURL.Query()
-			startParam := queryParams.Get("start")
-
-			if startParam == "" {
-				log.Printf("⚠️ Warning: 'start' parameter missing in Next URL for region '%s'", region)
-				break
-			}
-			log.Printf("🔍 Next pagination token for region '%s': %s", region, maskToken(startParam))
-			options.SetStart(startParam)
-		} else {
+		if result.Next == nil {
 			break
 		}
+		start := nextPageStart(result.Next.Href, region)
+		if start == "" {
+			break
+		}
+		options.SetStart(start)
 	}
 
-	log.Printf("✅ Fetched %d instances for region '%s' and resource group '%s'", len(instances), region, resourceGroupName)
-	return instances, nil
-}
-
-// Cache for resource group IDs to reduce redundant API calls
-var resourceGroupIDCache = sync.Map{}
-
-// Updated getResourceGroupID to use caching
-func getResourceGroupID(apiKey, resourceGroupName string) (string, error) {
-	log.Printf("🔍 Resolving resource group name '%s' to ID", resourceGroupName)
-
-	if cachedID, found := resourceGroupIDCache.Load(resourceGroupName); found {
-		log.Printf("✅ Resource group '%s' resolved to cached ID '%s'", resourceGroupName, cachedID.(string))
-		return cachedID.(string), nil
-	}
-
-	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
-	resourceManagerService, err := resourcemanagerv2.NewResourceManagerV2(&resourcemanagerv2.ResourceManagerV2Options{
-		Authenticator: authenticator,
-	})
+	// Bare metal servers live in the same regional VPC endpoint
+	bareMetalServers, err := fetchBareMetalServers(vpcService, region, account, resourceGroupID, floatingIPMap, vniAddresses)
 	if err != nil {
-		log.Printf("❌ Failed to create resource manager service: %v", err)
-		return "", fmt.Errorf("failed to create resource manager service: %v", err)
+		log.Printf("⚠️ Warning: Could not fetch bare metal servers for region '%s' and resource group '%s': %v", region, resourceGroupName, err)
 	}
+	instances = append(instances, bareMetalServers...)
 
-	options := resourceManagerService.NewListResourceGroupsOptions()
-	result, _, err := resourceManagerService.ListResourceGroups(options)
-	if err != nil {
-		log.Printf("❌ Failed to list resource groups: %v", err)
-		return "", fmt.Errorf("failed to list resource groups: %v", err)
+	if contains(enabledDiscoverers, discovererLoadBalancer) {
+		loadBalancers, err := fetchLoadBalancers(vpcService, region, account, resourceGroupID)
+		if err != nil {
+			log.Printf("⚠️ Warning: Could not fetch load balancers for region '%s' and resource group '%s': %v", region, resourceGroupName, err)
+		}
+		instances = append(instances, loadBalancers...)
 	}
 
-	for _, group := range result.Resources {
-		if *group.Name == resourceGroupName {
-			resourceGroupIDCache.Store(resourceGroupName, *group.ID) // Cache the ID
-			log.Printf("✅ Resource group '%s' resolved to ID '%s'", resourceGroupName, *group.ID)
-			return *group.ID, nil
-		}
+	for i := range instances {
+		instances[i].ResourceGroup = resourceGroup.Name
+		instances[i].ResourceGroupID = resourceGroup.ID
 	}
 
-	log.Printf("❌ Resource group '%s' not found", resourceGroupName)
-	return "", fmt.Errorf("resource group %s not found", resourceGroupName)
+	// Tags of every resource of the region and resource group in a few bulk queries
+	attachTags(apiKey, account, instances)
+
+	log.Printf("✅ Fetched %d instances for region '%s' and resource group '%s'", len(instances), region, resourceGroupName)
+	return instances, nil
 }
 
-func fetchInstanceIPs(apiKey, region string) (map[string]Instance, error) {
-	authenticator := &core.IamAuthenticator{ApiKey: apiKey}
-	vpcService, err := vpcv1.NewVpcV1(&vpcv1.VpcV1Options{Authenticator: authenticator})
+func fetchInstanceIPs(apiKey, account, region string) (map[string]Instance, error) {
+	correctedRegion := strings.TrimSuffix(region, "-1") // Ensure correct region format
+	vpcService, vpcServiceURL, err := clientsFor(account, apiKey).vpcClient(correctedRegion)
 	if err != nil {
 		return nil, err
 	}
-
-	correctedRegion := strings.TrimSuffix(region, "-1") // Ensure correct region format
-	vpcServiceURL := fmt.Sprintf("https://%s.iaas.cloud.ibm.com/v1", correctedRegion)
-	vpcService.SetServiceURL(vpcServiceURL)
 	log.Printf("✅ Using VPC service URL: %s", maskURL(vpcServiceURL))
 
 	// Fetch all floating IPs first
-	floatingIPMap, err := fetchFloatingIPs(vpcService)
+	floatingIPMap, err := fetchFloatingIPs(vpcService, correctedRegion)
 	if err != nil {
 		log.Printf("⚠️ Warning: Could not fetch floating IPs: %v", err)
 	}
 
-	// Fetch instances
-	options := vpcService.NewListInstancesOptions()
-	instancesResult, _, err := vpcService.ListInstances(options)
+	vniAddresses, err := fetchVirtualNetworkInterfaceIPs(vpcService, correctedRegion)
 	if err != nil {
-		log.Printf("❌ Error fetching instances from VPC: %v", err)
-		return nil, err
+		log.Printf("⚠️ Warning: Could not fetch virtual network interface addresses: %v", err)
 	}
 
+	// Fetch instances
+	options := vpcService.NewListInstancesOptions()
 	instanceMap := make(map[string]Instance)
 
-	for _, instance := range instancesResult.Instances {
-		var privateIP, publicIP string
-
-		for _, iface := range instance.NetworkInterfaces {
-			if iface.PrimaryIP != nil {
-				privateIP = *iface.PrimaryIP.Address
-			}
-
-			// Check if this interface has a mapped floating IP
-			if iface.ID != nil {
-				if ip, found := floatingIPMap[*iface.ID]; found {
-					publicIP = ip
-					log.Printf("🌍 Public IP %s assigned to instance %s", maskIP(publicIP), *instance.Name)
-				}
-			}
+	for {
+		instancesResult, _, err := retryCall("vpc", "ListInstances", vpcService.ListInstances, options)
+		if err != nil {
+			log.Printf("❌ Error fetching instances from VPC: %v", err)
+			return nil, err
 		}
 
-		if publicIP == "" {
-			log.Printf("⚠️ Instance %s (%s) in %s has no public IP assigned!", *instance.Name, *instance.ID, correctedRegion)
+		for _, instance := range instancesResult.Instances {
+			instanceMap[*instance.ID] = vpcInstance(instance, correctedRegion, account, floatingIPMap, vniAddresses)
 		}
 
-		instanceMap[*instance.ID] = Instance{
-			Name:             *instance.Name,
-			ID:               *instance.ID,
-			Region:           correctedRegion,
-			PrivateIP:        privateIP,
-			PublicIP:         publicIP, // ✅ Now correctly assigned
-			Status:           *instance.Status,
-			AvailabilityZone: *instance.Zone.Name,
-			InstanceID:       *instance.CRN,
+		if instancesResult.Next == nil {
+			break
+		}
+		start := nextPageStart(instancesResult.Next.Href, correctedRegion)
+		if start == "" {
+			break
 		}
+		options.SetStart(start)
 	}
 
 	return instanceMap, nil
 }
 
-func fetchFloatingIPs(vpcService *vpcv1.VpcV1) (map[string]string, error) {
-	options := vpcService.NewListFloatingIpsOptions()
-	result, _, err := vpcService.ListFloatingIps(options)
-	if err != nil {
-		log.Printf("❌ Error fetching floating IPs: %v", err)
-		return nil, err
+// vpcInstance maps a VSI to an Instance. The target addresses come from the primary interface, every NIC is
+// kept in Interfaces.
+func vpcInstance(instance vpcv1.Instance, region, account string, floatingIPMap map[string]string, vniAddresses map[string][]string) Instance {
+	interfaces := vpcInstanceInterfaces(instance, floatingIPMap, vniAddresses)
+	privateIP, publicIP := primaryAddresses(interfaces)
+
+	profile := ""
+	if instance.Profile != nil && instance.Profile.Name != nil {
+		profile = *instance.Profile.Name
+	}
+
+	return Instance{
+		Name:             *instance.Name,
+		ID:               *instance.ID,
+		Region:           region,
+		Account:          account,
+		Status:           *instance.Status,
+		AvailabilityZone: *instance.Zone.Name,
+		InstanceID:       *instance.CRN,
+		PrivateIP:        privateIP,
+		PublicIP:         publicIP,
+		PrivateIPv6:      primaryIPv6(interfaces),
+		Profile:          profile,
+		ResourceType:     resourceTypeInstance,
+		Interfaces:       interfaces,
 	}
+}
 
+// fetchFloatingIPs maps floating IP addresses by the ID of the network interface, virtual network interface
+// and reserved IP they are bound to
+func fetchFloatingIPs(vpcService *vpcv1.VpcV1, region string) (map[string]string, error) {
 	floatingIPMap := make(map[string]string)
+	options := vpcService.NewListFloatingIpsOptions()
 
-	for _, fip := range result.FloatingIps {
-		if fip.Target == nil {
-			log.Printf("⚠️ Floating IP %s has no target assigned! Skipping...", maskIP(*fip.Address))
-			continue
+	for {
+		result, _, err := retryCall("vpc", "ListFloatingIps", vpcService.ListFloatingIps, options)
+		if err != nil {
+			log.Printf("❌ Error fetching floating IPs: %v", err)
+			return nil, err
 		}
 
-		switch target := fip.Target.(type) {
-		case *vpcv1.FloatingIPTargetNetworkInterfaceReference:
-			if target.ID != nil && fip.Address != nil {
+		for _, fip := range result.FloatingIps {
+			if fip.Address == nil {
+				continue
+			}
+			if fip.Target == nil {
+				log.Printf("⚠️ Floating IP %s has no target assigned! Skipping...", maskIP(*fip.Address))
+				continue
+			}
+
+			// UnmarshalFloatingIPTarget decodes every target, whatever its resource_type, into the generic model
+			target, ok := fip.Target.(*vpcv1.FloatingIPTarget)
+			if !ok {
+				log.Printf("⚠️ Floating IP %s has an unsupported target (Target type: %T)", maskIP(*fip.Address), fip.Target)
+				continue
+			}
+			if target.ResourceType != nil && *target.ResourceType == vpcv1.FloatingIPTargetPublicGatewayReferenceResourceTypePublicGatewayConst {
+				continue // Public gateways are not scrape targets
+			}
+			if target.ID != nil {
 				floatingIPMap[*target.ID] = *fip.Address
-				log.Printf("✅ Floating IP %s mapped to VM Network Interface ID %s", maskIP(*fip.Address), *target.ID)
+				log.Printf("✅ Floating IP %s mapped to %s ID %s", maskIP(*fip.Address), stringValue(target.ResourceType), *target.ID)
 			}
-		default:
-			log.Printf("⚠️ Floating IP %s is not attached to a network interface (Target type: %T)", maskIP(*fip.Address), target)
+			// Floating IPs of virtual network interfaces are also keyed by their primary reserved IP
+			mapReservedIPFloatingIP(floatingIPMap, target.PrimaryIP, *fip.Address)
+		}
+
+		if result.Next == nil {
+			break
 		}
+		start := nextPageStart(result.Next.Href, region)
+		if start == "" {
+			break
+		}
+		options.SetStart(start)
 	}
 
 	return floatingIPMap, nil
 }
 
+// mapReservedIPFloatingIP also keys a floating IP by the reserved IP of its virtual network interface
+func mapReservedIPFloatingIP(floatingIPMap map[string]string, reservedIP *vpcv1.ReservedIPReference, address string) {
+	if reservedIP != nil && reservedIP.ID != nil {
+		floatingIPMap[*reservedIP.ID] = address
+	}
+}
+
 func instanceHandler(w http.ResponseWriter, r *http.Request) {
 	accounts := r.URL.Query().Get("accounts")
 	if accounts == "" {
@@ -636,8 +574,28 @@ func instanceHandler(w http.ResponseWriter, r *http.Request) {
 	accountList := strings.Split(accounts, ",")
 	regionList := strings.Split(regions, ",")
 	resourceGroupList := strings.Split(resourceGroups, ",")
+
+	filter, err := getFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The daemon snapshot is already complete, no need to query IBM Cloud per request
+	if daemonMode {
+		instances, ok := instancesForRequest(accountList, regionList, resourceGroupList)
+		if !ok {
+			writeSnapshotUnavailable(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(relabelInstances(applyFilter(instances, filter), httpRelabelConfigs))
+		return
+	}
+
+	reloadAccountConfigs()
+
 	var allInstances []Instance
-	instanceCache := make(map[string]map[string]Instance) // Cache IPs per region
 
 	var wg sync.WaitGroup
 	instanceChan := make(chan []Instance)
@@ -646,33 +604,13 @@ func instanceHandler(w http.ResponseWriter, r *http.Request) {
 		wg.Add(1)
 		go func(account string) {
 			defer wg.Done()
-			instances, err := fetchAllInstances(account, resourceGroupList)
+			// Discovery already fills the addresses and interfaces of every resource type
+			instances, err := fetchAllInstances(account, regionList, resourceGroupList)
 			if err != nil {
 				log.Printf("Error fetching instances for %s: %v", account, err)
 				return
 			}
 
-			for i, inst := range instances {
-				if !contains(regionList, inst.Region) {
-					continue
-				}
-
-				if _, exists := instanceCache[inst.Region]; !exists {
-					apiKey, err := getAPIKey(account)
-					if err != nil {
-						log.Printf("Error fetching API key for %s: %v", account, err)
-						continue
-					}
-					instanceCache[inst.Region], _ = fetchInstanceIPs(apiKey, inst.Region)
-				}
-
-				if ipInfo, found := instanceCache[inst.Region][inst.ID]; found {
-					instances[i].PrivateIP = ipInfo.PrivateIP
-					instances[i].PublicIP = ipInfo.PublicIP
-					instances[i].Profile = ipInfo.Profile
-				}
-			}
-
 			instanceChan <- instances
 		}(account)
 	}
@@ -689,7 +627,7 @@ func instanceHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("✅ Total instances fetched: %d", len(allInstances))
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(allInstances)
+	json.NewEncoder(w).Encode(relabelInstances(applyFilter(allInstances, filter), httpRelabelConfigs))
 }
 
 func contains(slice []string, item string) bool {
@@ -711,12 +649,14 @@ Usage:
   -accounts string
         Comma-separated list of IBM Cloud accounts (default "account1,account2")
   -regions string
-        Comma-separated list of IBM Cloud regions (default "us-east")
+        Comma-separated list of IBM Cloud regions, or "all" (default "us-east")
 
 Endpoints:
   /instances - Fetch instances from specified accounts and regions
   /help - Display this help message
   /prometheus - Prometheus metrics endpoint
+  /http_sd - Prometheus http_sd_configs endpoint (host:port targets with __meta_ibmcloud_* labels)
+  /metrics - IBM Cloud API call, retry and failure counters
 
 Examples:
   Fetch instances from default accounts and regions:
@@ -724,6 +664,9 @@ Examples:
 
   Fetch instances from specific accounts and regions:
     curl "http://localhost:8080/instances?accounts=account1,account2&regions=us-east,eu-de"
+
+  Serve http_sd targets scraping node_exporter on port 9100:
+    curl "http://localhost:8080/http_sd?accounts=account1&regions=us-east&port=9100"
 `, version)
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte(helpText))
@@ -754,6 +697,158 @@ func prometheusHandler(w http.ResponseWriter, r *http.Request) {
 	regionList := strings.Split(regions, ",")
 	resourceGroupList := strings.Split(resourceGroups, ",")
 
+	job, isJob, err := requestedJob(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	filter, err := getFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var allInstances []Instance
+	var ok bool
+	baseOptions := defaultTargetOptions()
+	if isJob {
+		allInstances, ok = jobInstances(job)
+		baseOptions = job.targetOptions()
+	} else {
+		allInstances, ok = instancesForRequest(accountList, regionList, resourceGroupList)
+	}
+	if !ok {
+		writeSnapshotUnavailable(w)
+		return
+	}
+	allInstances = filterByResourceType(allInstances, splitNonEmpty(r.URL.Query().Get("resource_types")))
+	allInstances = applyFilter(allInstances, filter)
+
+	options, err := getTargetOptions(r, baseOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var targets []TargetGroup
+	switch targetMode := getTargetMode(r); targetMode {
+	case targetModeInstance:
+		targets = buildPrometheusTargetGroups(allInstances, options)
+	case targetModeListener:
+		targets = buildListenerTargetGroups(allInstances, "")
+	default:
+		http.Error(w, fmt.Sprintf("Invalid target_mode %q", targetMode), http.StatusBadRequest)
+		return
+	}
+
+	// Write to file if outputFile is specified. Background discovery keeps the file up to date itself; writing
+	// the targets of a single request there would replace its complete list and race its backup rotation.
+	if outputFile != "" && schedulerRunning {
+		if r.URL.Query().Get("output_file") != "" {
+			log.Printf("⚠️ Warning: Ignoring output_file %s, background discovery writes the file_sd output", outputFile)
+		}
+	} else if outputFile != "" {
+		if err := writeSDConfig(outputFile, applyRelabelConfigs(targets, fileSDRelabelConfigs), sdBackups); err != nil {
+			log.Printf("Error writing to output file: %v", err)
+			http.Error(w, "Failed to write to output file", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Prometheus file-based service discovery JSON written to %s", outputFile)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applyRelabelConfigs(targets, httpRelabelConfigs))
+}
+
+// buildPrometheusTargetGroups converts instances into the target groups served by /prometheus and written to file_sd
+func buildPrometheusTargetGroups(instances []Instance, options targetOptions) []TargetGroup {
+	now := time.Now()
+	targets := []TargetGroup{}
+	for _, instance := range instances {
+		// Load balancers are probed per listener, see buildListenerTargetGroups
+		if instance.ResourceType == resourceTypeLoadBalancer {
+			continue
+		}
+		if reason := excludedFromTargets(instance, options.States, now); reason != "" {
+			log.Printf("🔕 Instance %s excluded from targets: %s", instance.Name, reason)
+			continue
+		}
+
+		iface, found := selectInterface(instance, options.Interface)
+		if !found {
+			log.Printf("⚠️ Instance %s has no interface matching '%s', skipping target", instance.Name, options.Interface)
+			continue
+		}
+		if options.address(iface) == "" && options.Template == nil {
+			log.Printf("⚠️ Instance %s has no private IP on interface '%s', skipping target", instance.Name, options.Interface)
+			continue
+		}
+
+		annotations := parseScrapeAnnotations(instance.Name, instance.Tags)
+		labels := instanceLabels(instance, iface, annotations)
+
+		groupTargets, err := instanceTargets(instance, iface, options, annotations)
+		if err != nil {
+			log.Printf("⚠️ Instance %s: %v, skipping target", instance.Name, err)
+			continue
+		}
+
+		targets = append(targets, TargetGroup{
+			Targets: groupTargets,
+			Labels:  labels,
+		})
+	}
+
+	return targets
+}
+
+// instanceLabels returns the labels of an instance's target group as served by /prometheus
+func instanceLabels(instance Instance, iface NetworkInterface, annotations scrapeAnnotations) map[string]string {
+	labels := map[string]string{
+		"instance":          instance.Name,
+		"region":            instance.Region,
+		"account":           instance.Account,
+		"status":            instance.Status,
+		"private_ip":        instance.PrivateIP,
+		"public_ip":         instance.PublicIP,
+		"private_ipv6":      instance.PrivateIPv6,
+		"public_ipv6":       instance.PublicIPv6,
+		"instance_id":       instance.InstanceID,
+		"availability_zone": instance.AvailabilityZone,
+		"profile":           instance.Profile,
+		"resource_group":    instance.ResourceGroup,
+		"resource_type":     instance.ResourceType,
+		"interface_name":    iface.Name,
+		"interface_subnet":  iface.Subnet,
+		"interface_ipv6":    iface.IPv6,
+	}
+
+	if instance.Workspace != "" {
+		labels["workspace"] = instance.Workspace
+		labels["workspace_id"] = instance.WorkspaceID
+	}
+	if instance.Hostname != "" {
+		labels["hostname"] = instance.Hostname
+		labels["domain"] = instance.Domain
+	}
+	if instance.Cluster != "" {
+		labels["cluster"] = instance.Cluster
+		labels["cluster_id"] = instance.ClusterID
+		labels["cluster_type"] = instance.ClusterType
+		labels["worker_pool"] = instance.WorkerPool
+	}
+
+	addTagLabels(labels, "", instance.Tags)
+	annotations.addLabels(labels)
+	return labels
+}
+
+// collectInstances fetches instances for every account concurrently and keeps only those in the requested regions
+func collectInstances(accountList, regionList, resourceGroupList []string) []Instance {
+	reloadAccountConfigs()
+
 	var allInstances []Instance
 	instanceChan := make(chan []Instance)
 	var wg sync.WaitGroup
@@ -762,21 +857,13 @@ func prometheusHandler(w http.ResponseWriter, r *http.Request) {
 		wg.Add(1)
 		go func(account string) {
 			defer wg.Done()
-			instances, err := fetchAllInstances(account, resourceGroupList)
+			instances, err := fetchAllInstances(account, regionList, resourceGroupList)
 			if err != nil {
 				log.Printf("Error fetching instances for %s: %v", account, err)
 				return
 			}
 
-			// Filter instances by regions
-			filteredInstances := []Instance{}
-			for _, inst := range instances {
-				if contains(regionList, inst.Region) {
-					filteredInstances = append(filteredInstances, inst)
-				}
-			}
-
-			instanceChan <- filteredInstances
+			instanceChan <- instances
 		}(account)
 	}
 
@@ -789,56 +876,165 @@ func prometheusHandler(w http.ResponseWriter, r *http.Request) {
 		allInstances = append(allInstances, instances...)
 	}
 
-	targets := []map[string]interface{}{}
-	for _, instance := range allInstances {
-		labels := map[string]string{
-			"instance":          instance.Name,
-			"region":            instance.Region,
-			"account":           instance.Account,
-			"status":            instance.Status,
-			"private_ip":        instance.PrivateIP,
-			"public_ip":         instance.PublicIP,
-			"instance_id":       instance.InstanceID,
-			"availability_zone": instance.AvailabilityZone,
-			"profile":           instance.Profile,
-			"resource_group":    instance.Account,
-		}
+	return allInstances
+}
+
+// httpSDHandler serves targets following the Prometheus http_sd_config contract
+func httpSDHandler(w http.ResponseWriter, r *http.Request) {
+	accounts := r.URL.Query().Get("accounts")
+	if accounts == "" {
+		accounts = "account1,account2"
+	}
+
+	regions := r.URL.Query().Get("regions")
+	if regions == "" {
+		regions = "us-east"
+	}
 
-		// Add tags as separate labels
-		for i, tag := range instance.Tags {
-			labels[fmt.Sprintf("tag_%d", i)] = tag
+	resourceGroups := r.URL.Query().Get("resource_groups")
+	if resourceGroups == "" {
+		resourceGroups = "default"
+	}
+
+	job, isJob, err := requestedJob(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	filter, err := getFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Port: query parameter, then the job's port, then scrape_port from config.json
+	baseOptions := defaultTargetOptions()
+	if isJob {
+		baseOptions = job.targetOptions()
+	}
+	options, err := getTargetOptions(r, baseOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if options.Port == "" {
+		options.Port = viper.GetString("scrape_port")
+	}
+	if options.Port == "" {
+		options.Port = defaultScrapePort
+	}
+	if _, err := strconv.ParseUint(options.Port, 10, 16); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid port %q", options.Port), http.StatusBadRequest)
+		return
+	}
+
+	var allInstances []Instance
+	var ok bool
+	if isJob {
+		allInstances, ok = jobInstances(job)
+	} else {
+		allInstances, ok = instancesForRequest(strings.Split(accounts, ","), strings.Split(regions, ","), strings.Split(resourceGroups, ","))
+	}
+	if !ok {
+		writeSnapshotUnavailable(w)
+		return
+	}
+	allInstances = filterByResourceType(allInstances, splitNonEmpty(r.URL.Query().Get("resource_types")))
+	allInstances = applyFilter(allInstances, filter)
+
+	var targetGroups []TargetGroup
+	switch targetMode := getTargetMode(r); targetMode {
+	case targetModeInstance:
+		targetGroups = buildHTTPSDTargetGroups(allInstances, options)
+	case targetModeListener:
+		targetGroups = buildListenerTargetGroups(allInstances, metaLabelPrefix)
+	default:
+		http.Error(w, fmt.Sprintf("Invalid target_mode %q", targetMode), http.StatusBadRequest)
+		return
+	}
+
+	targetGroups = applyRelabelConfigs(targetGroups, httpRelabelConfigs)
+	log.Printf("✅ Serving %d http_sd target groups", len(targetGroups))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(targetGroups)
+}
+
+// buildHTTPSDTargetGroups converts instances into host:port targets with __meta_ibmcloud_* labels
+func buildHTTPSDTargetGroups(instances []Instance, options targetOptions) []TargetGroup {
+	// Prometheus expects an empty array rather than null when nothing matches
+	now := time.Now()
+	targetGroups := []TargetGroup{}
+	for _, instance := range instances {
+		if instance.ResourceType == resourceTypeLoadBalancer {
+			continue
+		}
+		if reason := excludedFromTargets(instance, options.States, now); reason != "" {
+			log.Printf("🔕 Instance %s excluded from http_sd targets: %s", instance.Name, reason)
+			continue
+		}
+		iface, found := selectInterface(instance, options.Interface)
+		if !found || (options.address(iface) == "" && options.Template == nil) {
+			log.Printf("⚠️ Instance %s has no private IP on interface '%s', skipping http_sd target", instance.Name, options.Interface)
+			continue
 		}
 
-		target := map[string]interface{}{
-			"targets": []string{instance.PrivateIP},
-			"labels":  labels,
+		labels := map[string]string{
+			metaLabelPrefix + "instance_name":     instance.Name,
+			metaLabelPrefix + "instance_id":       instance.ID,
+			metaLabelPrefix + "instance_crn":      instance.InstanceID,
+			metaLabelPrefix + "region":            instance.Region,
+			metaLabelPrefix + "zone":              instance.AvailabilityZone,
+			metaLabelPrefix + "account":           instance.Account,
+			metaLabelPrefix + "status":            instance.Status,
+			metaLabelPrefix + "profile":           instance.Profile,
+			metaLabelPrefix + "private_ip":        instance.PrivateIP,
+			metaLabelPrefix + "public_ip":         instance.PublicIP,
+			metaLabelPrefix + "private_ipv6":      instance.PrivateIPv6,
+			metaLabelPrefix + "public_ipv6":       instance.PublicIPv6,
+			metaLabelPrefix + "resource_type":     instance.ResourceType,
+			metaLabelPrefix + "resource_group":    instance.ResourceGroup,
+			metaLabelPrefix + "resource_group_id": instance.ResourceGroupID,
+			metaLabelPrefix + "interface_name":    iface.Name,
+			metaLabelPrefix + "interface_subnet":  iface.Subnet,
+			metaLabelPrefix + "interface_ipv6":    iface.IPv6,
 		}
-		targets = append(targets, target)
-	}
 
-	// Write to file if outputFile is specified
-	if outputFile != "" {
-		file, err := os.Create(outputFile)
-		if err != nil {
-			log.Printf("Error creating output file: %v", err)
-			http.Error(w, "Failed to create output file", http.StatusInternalServerError)
-			return
+		if instance.Workspace != "" {
+			labels[metaLabelPrefix+"workspace"] = instance.Workspace
+			labels[metaLabelPrefix+"workspace_id"] = instance.WorkspaceID
+		}
+		if instance.Hostname != "" {
+			labels[metaLabelPrefix+"hostname"] = instance.Hostname
+			labels[metaLabelPrefix+"domain"] = instance.Domain
+		}
+		if instance.Cluster != "" {
+			labels[metaLabelPrefix+"cluster"] = instance.Cluster
+			labels[metaLabelPrefix+"cluster_id"] = instance.ClusterID
+			labels[metaLabelPrefix+"cluster_type"] = instance.ClusterType
+			labels[metaLabelPrefix+"worker_pool"] = instance.WorkerPool
 		}
-		defer file.Close()
 
-		encoder := json.NewEncoder(file)
-		encoder.SetIndent("", "  ")
-		if err := encoder.Encode(targets); err != nil {
-			log.Printf("Error writing to output file: %v", err)
-			http.Error(w, "Failed to write to output file", http.StatusInternalServerError)
-			return
+		addTagLabels(labels, metaLabelPrefix, instance.Tags)
+
+		// prometheus_port tags replace the default scrape port
+		annotations := parseScrapeAnnotations(instance.Name, instance.Tags)
+		annotations.addLabels(labels)
+
+		targets, err := instanceTargets(instance, iface, options, annotations)
+		if err != nil {
+			log.Printf("⚠️ Instance %s: %v, skipping http_sd target", instance.Name, err)
+			continue
 		}
 
-		log.Printf("Prometheus file-based service discovery JSON written to %s", outputFile)
+		targetGroups = append(targetGroups, TargetGroup{
+			Targets: targets,
+			Labels:  labels,
+		})
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(targets)
+	return targetGroups
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -899,14 +1095,16 @@ func redisFallbackDemoHandler(w http.ResponseWriter, r *http.Request) {
 
 // Add a new endpoint to demonstrate Prometheus file versioning
 func prometheusVersioningDemoHandler(w http.ResponseWriter, r *http.Request) {
-	config := Config{
-		Accounts:       map[string]string{"default": "account1"},
-		Regions:        map[string]string{"default": "us-east"},
-		ResourceGroups: map[string]string{"default": "default"},
-		OutputSDFile:   "./prometheus_sd_demo.json",
+	instances := []Instance{
+		{Name: "Instance1", ID: "id1", Region: "us-east", Account: "account1", PrivateIP: "10.240.0.4", Status: "running"},
+		{Name: "Instance2", ID: "id2", Region: "us-south", Account: "account2", PrivateIP: "10.240.64.4", Status: "running"},
 	}
 
-	writeSDConfig(config)
+	if err := writeSDConfig("./prometheus_sd_demo.json", buildPrometheusTargetGroups(instances, targetOptions{Interface: interfacePrimary}), sdBackups); err != nil {
+		log.Printf("❌ Error writing demo Prometheus file: %v", err)
+		http.Error(w, "Failed to write Prometheus demo file", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(`{"status":"Prometheus file versioning demo completed"}`))
@@ -916,11 +1114,17 @@ func prometheusVersioningDemoHandler(w http.ResponseWriter, r *http.Request) {
 func main() {
 	// Define command-line arguments with fallback to viper (config.json)
 	accounts := flag.String("accounts", viper.GetString("accounts"), "Comma-separated list of IBM Cloud accounts")
-	regions := flag.String("regions", viper.GetString("regions"), "Comma-separated list of IBM Cloud regions")
+	regions := flag.String("regions", strings.Join(configDefaultList("regions"), ","), "Comma-separated list of IBM Cloud regions, or \"all\" for every region of the account")
 	port := flag.String("port", viper.GetString("port"), "Port to run the server on")
-	resourceGroups := flag.String("resource_groups", viper.GetString("resource_groups"), "Comma-separated list of IBM Cloud resource groups")
+	resourceGroups := flag.String("resource_groups", strings.Join(configDefaultList("resource_groups"), ","), "Comma-separated list of IBM Cloud resource groups")
 	showVersion := flag.Bool("version", false, "Show tool version")
 	outputSDFile := flag.String("output-sd-file", viper.GetString("output_sd_file"), "Path to output file_sd_configs JSON file")
+	sdBackupCount := flag.Int("sd-backups", getConfigInt("sd_backups", 1), "Number of .bak generations kept for the file_sd output (0 disables backups)")
+	refreshInterval := flag.Duration("refresh-interval", getConfigDuration("refresh_interval", 5*time.Minute), "Interval between discovery cycles refreshing the file_sd output (0 runs discovery only at startup)")
+	refreshJitter := flag.Duration("refresh-jitter", getConfigDuration("refresh_jitter", 30*time.Second), "Maximum random delay added to each refresh interval")
+	discoverers := flag.String("discoverers", strings.Join(viper.GetStringSlice("discoverers"), ","), "Comma-separated list of discovery backends: vpc, powervs, classic, kubernetes, loadbalancer (default \"vpc\")")
+	strategy := flag.String("discovery-strategy", viper.GetString("discovery_strategy"), "VPC instance discovery strategy: regional, global_search or compare (default \"regional\")")
+	daemon := flag.Bool("daemon", viper.GetBool("daemon"), "Serve HTTP responses from the last background discovery snapshot instead of querying IBM Cloud per request")
 	certFile := flag.String("cert", "", "Path to the TLS certificate file (optional)")
 	keyFile := flag.String("key", "", "Path to the TLS key file (optional)")
 	flag.Parse()
@@ -944,10 +1148,6 @@ func main() {
 	if *resourceGroups == "" {
 		*resourceGroups = "default"
 	}
-	if *outputSDFile == "" {
-		*outputSDFile = "./prometheus_sd.json"
-	}
-
 	// Log the configuration being used
 	log.Printf("✅ Using configuration: accounts=%s, regions=%s, port=%s, resource_groups=%s, output_sd_file=%s",
 		*accounts, *regions, *port, *resourceGroups, *outputSDFile)
@@ -960,27 +1160,81 @@ func main() {
 	log.Printf("   - Resource Groups: %s (from %s)", *resourceGroups, getConfigSource("resource_groups"))
 	log.Printf("   - Output SD File: %s (from %s)", *outputSDFile, getConfigSource("output_sd_file"))
 
-	// Create the Prometheus file-based service discovery JSON file if outputSDFile is provided
-	if *outputSDFile != "" {
-		config := Config{
-			Accounts:       map[string]string{"default": *accounts},
-			Regions:        map[string]string{"default": *regions},
-			ResourceGroups: map[string]string{"default": *resourceGroups},
-			OutputSDFile:   *outputSDFile,
+	sdBackups = *sdBackupCount
+	daemonMode = *daemon
+
+	if *discoverers != "" {
+		enabledDiscoverers = strings.Split(*discoverers, ",")
+	}
+	for _, name := range enabledDiscoverers {
+		if _, found := accountDiscoverers[name]; !found && name != discovererVPC && name != discovererLoadBalancer {
+			log.Printf("⚠️ Warning: Unknown discoverer '%s' ignored", name)
 		}
-		writeSDConfig(config) // Ensure this function is used
-		log.Printf("✅ Prometheus file-based service discovery JSON written to %s", *outputSDFile)
+	}
+	log.Printf("🔍 Enabled discoverers: %v", enabledDiscoverers)
+
+	if *strategy != "" {
+		if !validDiscoveryStrategy(*strategy) {
+			log.Fatalf("❌ Unknown discovery strategy '%s', use regional, global_search or compare", *strategy)
+		}
+		discoveryStrategy = *strategy
+	}
+	log.Printf("🔍 VPC discovery strategy: %s", discoveryStrategy)
+
+	if text := viper.GetString("target_template"); text != "" {
+		tmpl, err := parseTargetTemplate(text)
+		if err != nil {
+			log.Fatalf("❌ Invalid target_template in config.json: %v", err)
+		}
+		configTargetTemplate = tmpl
+	}
+
+	var err error
+	if httpRelabelConfigs, err = loadRelabelConfigs("http_relabel_configs"); err != nil {
+		log.Fatalf("❌ Invalid relabeling configuration: %v", err)
+	}
+	if fileSDRelabelConfigs, err = loadRelabelConfigs("file_sd_relabel_configs"); err != nil {
+		log.Fatalf("❌ Invalid relabeling configuration: %v", err)
+	}
+
+	jobs, err := loadScrapeJobs()
+	if err != nil {
+		log.Fatalf("❌ Invalid jobs configuration: %v", err)
+	}
+	scrapeJobs = jobs
+	for _, job := range scrapeJobs {
+		log.Printf("🔍 Job %s: output_file=%s", job.Name, job.OutputFile)
+	}
+
+	// Run discovery in the background so the file_sd output stays fresh without HTTP calls. It is opt-in:
+	// without an output file, daemon mode or jobs, every request queries IBM Cloud and may write output_file.
+	// Jobs are always served from the snapshot, so they need the scheduler too.
+	if *outputSDFile != "" || daemonMode || len(scrapeJobs) > 0 {
+		scheduler := &discoveryScheduler{
+			accounts:       strings.Split(*accounts, ","),
+			regions:        strings.Split(*regions, ","),
+			resourceGroups: strings.Split(*resourceGroups, ","),
+			interval:       *refreshInterval,
+			jitter:         *refreshJitter,
+			outputFile:     *outputSDFile,
+		}
+		schedulerRunning = true
+		go scheduler.run()
+		log.Printf("🔄 Background discovery every %s (jitter up to %s), daemon mode: %t", *refreshInterval, *refreshJitter, daemonMode)
 	}
 
 	// Start the HTTP server
-	http.HandleFunc("/instances", func(w http.ResponseWriter, r *http.Request) {
-		r.URL.RawQuery = fmt.Sprintf("accounts=%s&regions=%s&resource_groups=%s", *accounts, *regions, *resourceGroups)
-		instanceHandler(w, r)
-	})
+	http.HandleFunc("/instances", withQueryDefaults(instanceHandler, map[string]string{
+		"accounts":        *accounts,
+		"regions":         *regions,
+		"resource_groups": *resourceGroups,
+	}))
 
 	http.HandleFunc("/help", helpHandler)
 	http.HandleFunc("/prometheus", prometheusHandler)
+	http.HandleFunc("/http_sd", httpSDHandler)
 	http.HandleFunc("/health", healthCheckHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 	http.HandleFunc("/masking-demo", maskingDemoHandler)
 	http.HandleFunc("/redis-fallback-demo", redisFallbackDemoHandler)
 	http.HandleFunc("/prometheus-versioning-demo", prometheusVersioningDemoHandler)
@@ -995,6 +1249,21 @@ func main() {
 	}
 }
 
+// withQueryDefaults fills in the query parameters a request leaves out with the given defaults, keeping every
+// parameter the request sets, such as filter
+func withQueryDefaults(handler http.HandlerFunc, defaults map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		for key, value := range defaults {
+			if query.Get(key) == "" && value != "" {
+				query.Set(key, value)
+			}
+		}
+		r.URL.RawQuery = query.Encode()
+		handler(w, r)
+	}
+}
+
 // Helper function to determine the source of a configuration
 func getConfigSource(key string) string {
 	if flag.Lookup(key) != nil && flag.Lookup(key).Value.String() != "" {
@@ -1006,9 +1275,192 @@ func getConfigSource(key string) string {
 	return "default value"
 }
 
+// nextPageStart extracts the 'start' pagination token from a VPC collection's next link
+func nextPageStart(nextHref *string, region string) string {
+	if nextHref == nil {
+		return ""
+	}
+
+	nextURL, err := url.Parse(*nextHref)
+	if err != nil {
+		log.Printf("⚠️ Warning: Failed to parse Next URL for region '%s': %v", region, err)
+		return ""
+	}
+
+	startParam := nextURL.Query().Get("start")
+	if startParam == "" {
+		log.Printf("⚠️ Warning: 'start' parameter missing in Next URL for region '%s'", region)
+		return ""
+	}
+	log.Printf("🔍 Next pagination token for region '%s': %s", region, maskToken(startParam))
+	return startParam
+}
+
+// getIBMCloudJSON performs an authenticated GET against an IBM Cloud REST API that has no SDK in this tool,
+// retried with the policy of api
+func getIBMCloudJSON(authenticator *core.IamAuthenticator, api, requestURL string, headers map[string]string, result interface{}) error {
+	token, err := authenticator.GetToken()
+	if err != nil {
+		return fmt.Errorf("failed to get IAM token: %v", err)
+	}
+
+	resp, err := retryRequest(api, "GET "+maskURL(requestURL), func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Accept", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %v", maskURL(requestURL), err)
+	}
+	return nil
+}
+
+// Datacenter metros that belong to an IBM Cloud region. Standalone datacenters outside of any region (ams, par,
+// sng, mil, osl, seo, sjc, mex, hkg, ...) are left out on purpose.
+var datacenterRegions = map[string]string{
+	"dal": "us-south",
+	"wdc": "us-east",
+	"tor": "ca-tor",
+	"mon": "ca-mon",
+	"sao": "br-sao",
+	"lon": "eu-gb",
+	"fra": "eu-de",
+	"mad": "eu-es",
+	"tok": "jp-tok",
+	"osa": "jp-osa",
+	"syd": "au-syd",
+	"che": "in-che",
+}
+
+// regionForDatacenter maps a datacenter such as dal12 or eu-de-1 to its region, so one job can cover all generations
+func regionForDatacenter(datacenter string) string {
+	if region, found := datacenterRegions[strings.TrimRight(datacenter, "0123456789")]; found {
+		return region
+	}
+	// Zones named after their region, e.g. eu-de-1, or PowerVS zones that are a region, e.g. us-south
+	region := datacenter
+	if i := strings.LastIndex(datacenter, "-"); i > 0 && strings.Count(datacenter, "-") == 2 {
+		region = datacenter[:i]
+	}
+	for _, known := range datacenterRegions {
+		if region == known {
+			return region
+		}
+	}
+	return "" // Standalone datacenter, only reported as zone
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// filterByResourceType keeps instances whose resource type is listed; an empty list keeps everything
+func filterByResourceType(instances []Instance, resourceTypes []string) []Instance {
+	if len(resourceTypes) == 0 {
+		return instances
+	}
+
+	filtered := []Instance{}
+	for _, inst := range instances {
+		if contains(resourceTypes, inst.ResourceType) {
+			filtered = append(filtered, inst)
+		}
+	}
+	return filtered
+}
+
+// getTargetMode reads the target_mode query parameter, falling back to config.json and then to instance targets
+func getTargetMode(r *http.Request) string {
+	if targetMode := r.URL.Query().Get("target_mode"); targetMode != "" {
+		return targetMode
+	}
+	if targetMode := viper.GetString("target_mode"); targetMode != "" {
+		return targetMode
+	}
+	return targetModeInstance
+}
+
+// getTargetOptions applies the interface, prefer_ipv6, port and target_template query parameters on top of base
+func getTargetOptions(r *http.Request, base targetOptions) (targetOptions, error) {
+	options := base
+	if targetInterface := r.URL.Query().Get("interface"); targetInterface != "" {
+		options.Interface = targetInterface
+	}
+	if port := r.URL.Query().Get("port"); port != "" {
+		options.Port = port
+	}
+	if preferIPv6, err := strconv.ParseBool(r.URL.Query().Get("prefer_ipv6")); err == nil {
+		options.PreferIPv6 = preferIPv6
+	}
+	if states := r.URL.Query().Get("states"); states != "" {
+		options.States = strings.Split(states, ",")
+	}
+	if text := r.URL.Query().Get("target_template"); text != "" {
+		tmpl, err := parseTargetTemplate(text)
+		if err != nil {
+			return options, err
+		}
+		options.Template = tmpl
+	}
+	return options, nil
+}
+
+// defaultTargetOptions returns the configured target selection, the primary NIC's IPv4 address unless configured otherwise
+func defaultTargetOptions() targetOptions {
+	options := targetOptions{
+		Interface:  interfacePrimary,
+		PreferIPv6: viper.GetBool("prefer_ipv6"),
+		Template:   configTargetTemplate,
+		States:     configuredTargetStates(),
+	}
+	if targetInterface := viper.GetString("target_interface"); targetInterface != "" {
+		options.Interface = targetInterface
+	}
+	return options
+}
+
+// splitNonEmpty splits a comma-separated list, returning nil for an empty string
+func splitNonEmpty(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
+
+// Helper function to read an integer from the config file with a default
+func getConfigInt(key string, defaultValue int) int {
+	if viper.IsSet(key) {
+		return viper.GetInt(key)
+	}
+	return defaultValue
+}
+
+// Helper function to read a duration (e.g. "5m") from the config file with a default
+func getConfigDuration(key string, defaultValue time.Duration) time.Duration {
+	if viper.IsSet(key) {
+		return viper.GetDuration(key)
+	}
+	return defaultValue
+}
+
 // Helper function to retrieve all accounts from the config file
 func getAllAccountsFromConfig() []string {
-	accounts := viper.GetStringMapString("accounts")
+	accounts := viper.GetStringMap("accounts") // Values are API keys or account objects
 	var accountList []string
 	for account := range accounts {
 		accountList = append(accountList, account)
@@ -1043,6 +1495,12 @@ func maskIP(ip string) string {
 	if len(parts) == 4 {
 		return parts[0] + ".***.***." + parts[3]
 	}
+
+	// IPv6: keep the first and last group, e.g. 2001:****:1
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		groups := strings.Split(parsed.String(), ":")
+		return groups[0] + ":****:" + groups[len(groups)-1]
+	}
 	return ip
 }
 
@@ -1071,33 +1529,115 @@ func maskSensitiveData(data string) string {
 	return data
 }
 
-// Add versioning for Prometheus output file
-func writeSDConfig(config Config) {
-	outputFile := config.OutputSDFile
-	backupFile := outputFile + ".bak"
+// writeSDConfig atomically writes target groups to the file_sd output, keeping `backups` older generations.
+// The data goes to a temp file in the same directory which is fsynced and renamed over the output,
+// so Prometheus never reads a half-written file.
+func writeSDConfig(outputFile string, targetGroups []TargetGroup, backups int) error {
+	if targetGroups == nil {
+		targetGroups = []TargetGroup{}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(targetGroups); err != nil {
+		return fmt.Errorf("failed to encode target groups: %v", err)
+	}
+
+	// Skip unchanged content so the backup history only holds real changes
+	if existing, err := os.ReadFile(outputFile); err == nil && bytes.Equal(existing, buf.Bytes()) {
+		log.Printf("ℹ️ Prometheus file %s is unchanged, skipping write", outputFile)
+		return nil
+	}
+
+	dir := filepath.Dir(outputFile)
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(outputFile)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName) // No-op once the rename succeeded
 
-	// Create a backup of the existing file
-	if _, err := os.Stat(outputFile); err == nil {
-		if err := os.Rename(outputFile, backupFile); err != nil {
+	if _, err := tmpFile.Write(buf.Bytes()); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync temp file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %v", err)
+	}
+	// CreateTemp uses 0600, but Prometheus usually runs as another user
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return fmt.Errorf("failed to set permissions on temp file: %v", err)
+	}
+
+	rotateSDBackups(outputFile, backups)
+
+	if err := os.Rename(tmpName, outputFile); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", outputFile, err)
+	}
+	syncDir(dir)
+
+	log.Printf("✅ Prometheus file successfully written to %s (%d target groups)", outputFile, len(targetGroups))
+	return nil
+}
+
+// backupFileName returns the name of a backup generation: .bak is the newest, then .bak.2, .bak.3, ...
+func backupFileName(outputFile string, generation int) string {
+	if generation == 1 {
+		return outputFile + ".bak"
+	}
+	return fmt.Sprintf("%s.bak.%d", outputFile, generation)
+}
+
+// rotateSDBackups shifts the backup generations and keeps the current output as the newest one
+func rotateSDBackups(outputFile string, backups int) {
+	if backups <= 0 {
+		return
+	}
+	if _, err := os.Stat(outputFile); err != nil {
+		return
+	}
+
+	os.Remove(backupFileName(outputFile, backups))
+	for generation := backups - 1; generation >= 1; generation-- {
+		from := backupFileName(outputFile, generation)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, backupFileName(outputFile, generation+1)); err != nil {
+			log.Printf("⚠️ Failed to rotate backup %s: %v", from, err)
+		}
+	}
+
+	// A hard link keeps the output in place until the new file is renamed over it
+	backupFile := backupFileName(outputFile, 1)
+	if err := os.Link(outputFile, backupFile); err != nil {
+		if err := copyFile(outputFile, backupFile); err != nil {
 			log.Printf("⚠️ Failed to create backup of output file: %v", err)
 			return
 		}
-		log.Printf("✅ Backup created: %s", backupFile)
 	}
+	log.Printf("✅ Backup created: %s", backupFile)
+}
 
-	file, err := os.Create(outputFile)
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
 	if err != nil {
-		log.Printf("❌ Error creating output file: %v", err)
-		return
+		return err
 	}
-	defer file.Close()
+	return os.WriteFile(dst, data, 0644)
+}
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(config); err != nil {
-		log.Printf("❌ Error encoding JSON: %v", err)
+// syncDir flushes the directory entry after a rename; not supported on every platform, so errors are ignored
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
 		return
 	}
-
-	log.Printf("✅ Prometheus file successfully written to %s", outputFile)
+	defer d.Close()
+	d.Sync()
 }
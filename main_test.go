@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteSDConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, outputFile string)
+		writes  []string // Target of each successive write
+		backups int
+		want    map[string]string // File suffix to its target, "" when the file must not exist
+		wantErr string
+	}{
+		{
+			name:    "first write",
+			writes:  []string{"10.0.0.1"},
+			backups: 2,
+			want:    map[string]string{"": "10.0.0.1", ".bak": ""},
+		},
+		{
+			name:    "unchanged rewrite is skipped",
+			writes:  []string{"10.0.0.1", "10.0.0.1"},
+			backups: 2,
+			want:    map[string]string{"": "10.0.0.1", ".bak": ""},
+		},
+		{
+			name:    "changed rewrite keeps a backup",
+			writes:  []string{"10.0.0.1", "10.0.0.2"},
+			backups: 2,
+			want:    map[string]string{"": "10.0.0.2", ".bak": "10.0.0.1", ".bak.2": ""},
+		},
+		{
+			name:    "rotation past the kept generations",
+			writes:  []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"},
+			backups: 2,
+			want:    map[string]string{"": "10.0.0.4", ".bak": "10.0.0.3", ".bak.2": "10.0.0.2", ".bak.3": ""},
+		},
+		{
+			name:    "backups disabled",
+			writes:  []string{"10.0.0.1", "10.0.0.2"},
+			backups: 0,
+			want:    map[string]string{"": "10.0.0.2", ".bak": ""},
+		},
+		{
+			name: "failed rename",
+			setup: func(t *testing.T, outputFile string) {
+				// A non-empty directory can not be replaced by a file
+				if err := os.MkdirAll(filepath.Join(outputFile, "keep"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			},
+			writes:  []string{"10.0.0.1"},
+			backups: 2,
+			want:    map[string]string{".bak": ""},
+			wantErr: "failed to replace",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outputFile := filepath.Join(dir, "prometheus_sd.json")
+			if test.setup != nil {
+				test.setup(t, outputFile)
+			}
+
+			var err error
+			for _, target := range test.writes {
+				err = writeSDConfig(outputFile, []TargetGroup{{Targets: []string{target}, Labels: map[string]string{}}}, test.backups)
+				if err != nil {
+					break
+				}
+			}
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("writeSDConfig() error = %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Fatalf("writeSDConfig() error = %v, want %q", err, test.wantErr)
+			}
+
+			for suffix, want := range test.want {
+				got := sdFileTarget(t, outputFile+suffix)
+				if got != want {
+					t.Errorf("target in prometheus_sd.json%s = %q, want %q", suffix, got, want)
+				}
+			}
+
+			// The temp file never outlives a write
+			if leftovers, _ := filepath.Glob(filepath.Join(dir, ".prometheus_sd.json.tmp-*")); len(leftovers) > 0 {
+				t.Errorf("temp files left behind: %v", leftovers)
+			}
+		})
+	}
+}
+
+// sdFileTarget returns the first target of a file_sd file, or "" when it does not exist
+func sdFileTarget(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	var targetGroups []TargetGroup
+	if err := json.Unmarshal(data, &targetGroups); err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+	if len(targetGroups) == 0 || len(targetGroups[0].Targets) == 0 {
+		return ""
+	}
+	return targetGroups[0].Targets[0]
+}
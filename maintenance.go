@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Tags that take an instance out of monitoring
+const (
+	tagMonitoring  = "monitoring"  // monitoring:disabled excludes the instance from targets
+	tagMaintenance = "maintenance" // maintenance:until=<RFC3339> excludes it until the timestamp has passed
+
+	targetStatesAll = "all"
+)
+
+// defaultTargetStates avoids up == 0 alerts for stopped or starting instances
+var defaultTargetStates = []string{"running"}
+
+// configuredTargetStates returns target_states from config.json, only running instances unless configured
+func configuredTargetStates() []string {
+	if viper.IsSet("target_states") {
+		return viper.GetStringSlice("target_states")
+	}
+	return defaultTargetStates
+}
+
+// excludedFromTargets returns why an instance gets no target, or an empty string when it should be scraped.
+// states lists the statuses that are scraped; empty or "all" scrapes every status.
+func excludedFromTargets(instance Instance, states []string, now time.Time) string {
+	if len(states) > 0 && !contains(states, targetStatesAll) && !contains(states, instance.Status) {
+		return fmt.Sprintf("status %s", instance.Status)
+	}
+
+	for _, tag := range instance.Tags {
+		key, value, isKeyValue := splitTag(tag)
+		if !isKeyValue {
+			continue
+		}
+
+		switch key {
+		case tagMonitoring:
+			if value == "disabled" {
+				return "tagged " + tag
+			}
+		case tagMaintenance:
+			// IBM Cloud tags cannot contain "=", so until:<timestamp> is accepted as well
+			timestamp, found := strings.CutPrefix(value, "until=")
+			if !found {
+				timestamp, found = strings.CutPrefix(value, "until:")
+			}
+			if !found {
+				continue
+			}
+			until, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				log.Printf("⚠️ Instance %s has an invalid maintenance tag %q, ignoring it: %v", instance.Name, tag, err)
+				continue
+			}
+			if now.Before(until) {
+				return "in maintenance until " + until.Format(time.RFC3339)
+			}
+		}
+	}
+
+	return ""
+}
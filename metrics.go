@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// apiCallMetrics counts IBM Cloud API calls, retries and failures per API for the /metrics endpoint
+type apiCallMetrics struct {
+	mu       sync.Mutex
+	calls    map[string]uint64
+	retries  map[[2]string]uint64 // API and retry reason
+	failures map[string]uint64
+}
+
+var apiMetrics = &apiCallMetrics{
+	calls:    make(map[string]uint64),
+	retries:  make(map[[2]string]uint64),
+	failures: make(map[string]uint64),
+}
+
+func (m *apiCallMetrics) call(api string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[api]++
+}
+
+func (m *apiCallMetrics) retry(api, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[[2]string{api, reason}]++
+}
+
+func (m *apiCallMetrics) fail(api string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[api]++
+}
+
+// metricsHandler serves the API call counters in the Prometheus text exposition format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	apiMetrics.mu.Lock()
+	defer apiMetrics.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP ibm_sd_api_calls_total IBM Cloud API call attempts, including retries.\n")
+	b.WriteString("# TYPE ibm_sd_api_calls_total counter\n")
+	for _, api := range sortedKeys(apiMetrics.calls) {
+		fmt.Fprintf(&b, "ibm_sd_api_calls_total{api=%q} %d\n", api, apiMetrics.calls[api])
+	}
+
+	b.WriteString("# HELP ibm_sd_api_retries_total IBM Cloud API calls retried, by reason (throttled, server_error, network).\n")
+	b.WriteString("# TYPE ibm_sd_api_retries_total counter\n")
+	retryKeys := make([][2]string, 0, len(apiMetrics.retries))
+	for key := range apiMetrics.retries {
+		retryKeys = append(retryKeys, key)
+	}
+	sort.Slice(retryKeys, func(i, j int) bool {
+		return retryKeys[i][0] < retryKeys[j][0] || (retryKeys[i][0] == retryKeys[j][0] && retryKeys[i][1] < retryKeys[j][1])
+	})
+	for _, key := range retryKeys {
+		fmt.Fprintf(&b, "ibm_sd_api_retries_total{api=%q,reason=%q} %d\n", key[0], key[1], apiMetrics.retries[key])
+	}
+
+	b.WriteString("# HELP ibm_sd_api_failures_total IBM Cloud API calls that failed after the last attempt.\n")
+	b.WriteString("# TYPE ibm_sd_api_failures_total counter\n")
+	for _, api := range sortedKeys(apiMetrics.failures) {
+		fmt.Fprintf(&b, "ibm_sd_api_failures_total{api=%q} %d\n", api, apiMetrics.failures[api])
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+func sortedKeys(counters map[string]uint64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
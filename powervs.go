@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+)
+
+const (
+	resourceTypePowerVSInstance = "pvm_instance"
+
+	// Resource controller catalog ID of the Power Systems Virtual Server service
+	powerVSResourceID = "abd259f0-9990-11e8-acc8-b9f54a8f1661"
+)
+
+// PowerVS API endpoints are per region, while workspaces report the datacenter (dal12) or availability zone
+// (eu-de-1) they live in. The region is the zone without its number, except for the metros below.
+var powerVSMetroRegions = map[string]string{
+	"dal": "us-south",
+	"wdc": "us-east",
+}
+
+var (
+	powerVSZoneNumber = regexp.MustCompile(`-?[0-9]+$`)
+	powerVSRegionName = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+)
+
+// powerVSAPIRegion returns the PowerVS API region of a workspace, derived from its region_id or else from the
+// location segment of its CRN
+func powerVSAPIRegion(workspace powerVSWorkspace) (string, error) {
+	zone := workspace.Zone
+	if zone == "" {
+		// crn:v1:bluemix:public:power-iaas:<zone>:a/<account>:<guid>::
+		if parts := strings.Split(workspace.CRN, ":"); len(parts) > 5 {
+			zone = parts[5]
+		}
+	}
+
+	region := powerVSZoneNumber.ReplaceAllString(strings.ToLower(zone), "")
+	if metroRegion, found := powerVSMetroRegions[region]; found {
+		region = metroRegion
+	}
+	if !powerVSRegionName.MatchString(region) {
+		return "", fmt.Errorf("cannot derive the PowerVS API region from zone %q of workspace %s", zone, workspace.Name)
+	}
+	return region, nil
+}
+
+// powerVSWorkspace is a PowerVS workspace (service instance) found through the resource controller
+type powerVSWorkspace struct {
+	Name            string
+	GUID            string
+	CRN             string
+	Zone            string
+	ResourceGroupID string
+}
+
+// pvmInstanceList is the subset of the PowerVS pvm-instances response used for discovery
+type pvmInstanceList struct {
+	PvmInstances []struct {
+		PvmInstanceID string  `json:"pvmInstanceID"`
+		ServerName    string  `json:"serverName"`
+		Status        string  `json:"status"`
+		SysType       string  `json:"sysType"`
+		OSType        string  `json:"osType"`
+		CRN           string  `json:"crn"`
+		Processors    float64 `json:"processors"`
+		Memory        float64 `json:"memory"`
+		Networks      []struct {
+			IPAddress   string `json:"ipAddress"`
+			ExternalIP  string `json:"externalIP"`
+			NetworkName string `json:"networkName"`
+			NetworkID   string `json:"networkID"`
+		} `json:"networks"`
+	} `json:"pvmInstances"`
+}
+
+// fetchPowerVSInstances enumerates the PowerVS workspaces of an account and lists their PVM instances
+func fetchPowerVSInstances(apiKey, account string, resourceGroups []string) ([]Instance, error) {
+	clients := clientsFor(account, apiKey)
+	authenticator := clients.authenticator
+
+	workspaces, err := listPowerVSWorkspaces(clients)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve resource group names so workspaces can be filtered like VPC instances
+	resourceGroupNames := make(map[string]string) // ID -> name
+	for _, nameOrID := range resourceGroups {
+		resourceGroup, err := resolveResourceGroup(apiKey, account, nameOrID)
+		if err != nil {
+			log.Printf("⚠️ Warning: Could not resolve resource group '%s' for PowerVS: %v", nameOrID, err)
+			continue
+		}
+		resourceGroupNames[resourceGroup.ID] = resourceGroup.Name
+	}
+
+	instances := []Instance{}
+	for _, workspace := range workspaces {
+		resourceGroupName, found := resourceGroupNames[workspace.ResourceGroupID]
+		if !found {
+			continue
+		}
+
+		apiRegion, err := powerVSAPIRegion(workspace)
+		if err != nil {
+			log.Printf("❌ Skipping PowerVS workspace '%s': %v", workspace.Name, err)
+			continue
+		}
+		workspaceInstances, err := fetchPowerVSWorkspaceInstances(authenticator, account, apiRegion, workspace)
+		if err != nil {
+			log.Printf("⚠️ Error fetching PowerVS instances for workspace '%s': %v", workspace.Name, err)
+			continue
+		}
+		for i := range workspaceInstances {
+			workspaceInstances[i].ResourceGroup = resourceGroupName
+			workspaceInstances[i].ResourceGroupID = workspace.ResourceGroupID
+		}
+		instances = append(instances, workspaceInstances...)
+	}
+
+	attachTags(apiKey, account, instances)
+
+	log.Printf("✅ Fetched %d PowerVS instances from %d workspaces for account %s", len(instances), len(workspaces), maskAccount(account))
+	return instances, nil
+}
+
+// listPowerVSWorkspaces pages through the resource controller for Power Systems Virtual Server instances
+func listPowerVSWorkspaces(clients *accountClients) ([]powerVSWorkspace, error) {
+	controllerService, err := clients.resourceControllerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := controllerService.NewListResourceInstancesOptions()
+	options.SetResourceID(powerVSResourceID)
+	options.SetLimit(100)
+
+	workspaces := []powerVSWorkspace{}
+	for {
+		result, _, err := retryCall("resource_controller", "ListResourceInstances", controllerService.ListResourceInstances, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list PowerVS workspaces: %v", err)
+		}
+
+		for _, resource := range result.Resources {
+			if resource.GUID == nil || resource.CRN == nil {
+				continue
+			}
+			workspaces = append(workspaces, powerVSWorkspace{
+				Name:            stringValue(resource.Name),
+				GUID:            *resource.GUID,
+				CRN:             *resource.CRN,
+				Zone:            stringValue(resource.RegionID),
+				ResourceGroupID: stringValue(resource.ResourceGroupID),
+			})
+		}
+
+		if result.NextURL == nil {
+			break
+		}
+		nextURL, err := url.Parse(*result.NextURL)
+		if err != nil || nextURL.Query().Get("start") == "" {
+			break
+		}
+		options.SetStart(nextURL.Query().Get("start"))
+	}
+
+	log.Printf("🔍 Found %d PowerVS workspaces", len(workspaces))
+	return workspaces, nil
+}
+
+// fetchPowerVSWorkspaceInstances lists the PVM instances of one workspace through the PowerVS API of its region
+func fetchPowerVSWorkspaceInstances(authenticator *core.IamAuthenticator, account, apiRegion string, workspace powerVSWorkspace) ([]Instance, error) {
+	requestURL := fmt.Sprintf("%s/pcloud/v1/cloud-instances/%s/pvm-instances", endpoint(account, "power_iaas", apiRegion), workspace.GUID)
+	log.Printf("🔍 Fetching PowerVS instances from %s", maskURL(requestURL))
+
+	var result pvmInstanceList
+	if err := getIBMCloudJSON(authenticator, "power_iaas", requestURL, map[string]string{"CRN": workspace.CRN}, &result); err != nil {
+		return nil, err
+	}
+
+	instances := []Instance{}
+	for _, pvm := range result.PvmInstances {
+		interfaces := []NetworkInterface{}
+		for i, network := range pvm.Networks {
+			iface := NetworkInterface{
+				Name:       network.NetworkName,
+				ID:         network.NetworkID,
+				Subnet:     network.NetworkName,
+				FloatingIP: network.ExternalIP,
+				Primary:    i == 0, // PowerVS lists the primary network first
+			}
+			iface.addAddress(network.IPAddress)
+			interfaces = append(interfaces, iface)
+		}
+		privateIP, publicIP := primaryAddresses(interfaces)
+
+		instances = append(instances, Instance{
+			Name:             pvm.ServerName,
+			ID:               pvm.PvmInstanceID,
+			Region:           regionForDatacenter(workspace.Zone),
+			Account:          account,
+			Status:           powerVSStatus(pvm.Status),
+			AvailabilityZone: workspace.Zone,
+			InstanceID:       pvm.CRN,
+			PrivateIP:        privateIP,
+			PublicIP:         publicIP,
+			PrivateIPv6:      primaryIPv6(interfaces),
+			Profile:          pvm.SysType,
+			ResourceType:     resourceTypePowerVSInstance,
+			Workspace:        workspace.Name,
+			WorkspaceID:      workspace.GUID,
+			Interfaces:       interfaces,
+		})
+	}
+
+	return instances, nil
+}
+
+// powerVSStatus maps PowerVS states onto the VPC status names so one filter covers both
+func powerVSStatus(status string) string {
+	switch strings.ToUpper(status) {
+	case "ACTIVE":
+		return "running"
+	case "SHUTOFF":
+		return "stopped"
+	default:
+		return strings.ToLower(status)
+	}
+}
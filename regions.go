@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/viper"
+)
+
+// regionsAll requests every region of the account, as returned by the VPC regions API
+const regionsAll = "all"
+
+// regionAllowlist returns the regions an account may be scanned in: region_allowlist.<account> from config.json,
+// else region_allowlist.default. An empty list allows every region.
+func regionAllowlist(account string) []string {
+	if allowlist := viper.GetStringSlice("region_allowlist." + account); len(allowlist) > 0 {
+		return allowlist
+	}
+	return viper.GetStringSlice("region_allowlist.default")
+}
+
+// resolveRegions returns the regional endpoints to contact for an account. Only "all" lists the regions
+// through the API; otherwise the requested regions are used as they are. Both are limited by the allowlist.
+func resolveRegions(apiKey, account string, requested []string) ([]string, error) {
+	regions := requested
+	if contains(requested, regionsAll) {
+		var err error
+		regions, err = getAllRegions(apiKey, account)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch regions: %v", err)
+		}
+	}
+
+	allowlist := regionAllowlist(account)
+	if len(allowlist) == 0 {
+		return regions, nil
+	}
+
+	allowed := []string{}
+	for _, region := range regions {
+		if contains(allowlist, region) {
+			allowed = append(allowed, region)
+		} else if !contains(requested, regionsAll) {
+			log.Printf("⚠️ Region %s is not in the allowlist of account %s, skipping it", region, maskAccount(account))
+		}
+	}
+	return allowed, nil
+}
+
+// regionSelected reports whether a region is part of a regions parameter, where "all" selects every region
+func regionSelected(regionList []string, region string) bool {
+	return contains(regionList, regionsAll) || contains(regionList, region)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// relabelConfig is one Prometheus-compatible relabel rule. Supported actions are replace, keep, drop,
+// labelmap, labeldrop and hashmod, with the same defaults as Prometheus.
+type relabelConfig struct {
+	SourceLabels []string `mapstructure:"source_labels"`
+	Separator    *string  `mapstructure:"separator"`
+	Regex        *string  `mapstructure:"regex"`
+	Modulus      uint64   `mapstructure:"modulus"`
+	TargetLabel  string   `mapstructure:"target_label"`
+	Replacement  *string  `mapstructure:"replacement"`
+	Action       string   `mapstructure:"action"`
+
+	regex *regexp.Regexp
+}
+
+var (
+	labelNamePattern     = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	relabelTargetPattern = regexp.MustCompile(`^(?:(?:[a-zA-Z_]|\$(?:\{\w+\}|\w+))+\w*)+$`) // Prometheus' check for replace targets
+)
+
+var (
+	httpRelabelConfigs   []relabelConfig // Applied to /prometheus and /http_sd responses
+	fileSDRelabelConfigs []relabelConfig // Applied to every file_sd output
+)
+
+// loadRelabelConfigs reads and validates a list of relabel rules from config.json
+func loadRelabelConfigs(key string) ([]relabelConfig, error) {
+	var configs []relabelConfig
+	if err := viper.UnmarshalKey(key, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", key, err)
+	}
+
+	for i := range configs {
+		config := &configs[i]
+		if config.Action == "" {
+			config.Action = "replace"
+		}
+
+		regex := "(.*)"
+		if config.Regex != nil {
+			regex = *config.Regex
+		}
+		compiled, err := regexp.Compile("^(?:" + regex + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: invalid regex %q: %v", key, i, regex, err)
+		}
+		config.regex = compiled
+
+		switch config.Action {
+		case "replace":
+			if config.TargetLabel == "" {
+				return nil, fmt.Errorf("%s[%d]: replace requires target_label", key, i)
+			}
+			// Like Prometheus, replace may build the target label from regex capture groups
+			if !relabelTargetPattern.MatchString(config.TargetLabel) {
+				return nil, fmt.Errorf("%s[%d]: %q is not a valid target_label", key, i, config.TargetLabel)
+			}
+		case "hashmod":
+			if config.TargetLabel == "" || config.Modulus == 0 {
+				return nil, fmt.Errorf("%s[%d]: hashmod requires target_label and a modulus above 0", key, i)
+			}
+			if !labelNamePattern.MatchString(config.TargetLabel) {
+				return nil, fmt.Errorf("%s[%d]: %q is not a valid label name", key, i, config.TargetLabel)
+			}
+		case "labelmap":
+			if !relabelTargetPattern.MatchString(config.replacement()) {
+				return nil, fmt.Errorf("%s[%d]: %q is not a valid labelmap replacement", key, i, config.replacement())
+			}
+		case "keep", "drop", "labeldrop":
+		default:
+			return nil, fmt.Errorf("%s[%d]: unsupported action %q", key, i, config.Action)
+		}
+	}
+
+	return configs, nil
+}
+
+// applyRelabelConfigs relabels every target the way Prometheus does, with the target in __address__.
+// Groups are split into one group per target since targets of a group may end up with different labels.
+func applyRelabelConfigs(targetGroups []TargetGroup, configs []relabelConfig) []TargetGroup {
+	if len(configs) == 0 {
+		return targetGroups
+	}
+
+	relabeled := []TargetGroup{}
+	for _, group := range targetGroups {
+		for _, target := range group.Targets {
+			labels := make(map[string]string, len(group.Labels)+1)
+			for name, value := range group.Labels {
+				labels[name] = value
+			}
+			labels["__address__"] = target
+
+			if !relabel(labels, configs) {
+				continue
+			}
+
+			address := labels["__address__"]
+			delete(labels, "__address__")
+			if address == "" {
+				continue
+			}
+			relabeled = append(relabeled, TargetGroup{Targets: []string{address}, Labels: labels})
+		}
+	}
+
+	return relabeled
+}
+
+// relabelInstances applies relabel rules to the label set /prometheus serves for every instance, with the
+// address of its primary interface in __address__. Dropped instances are left out, the others are returned
+// with the resulting labels.
+func relabelInstances(instances []Instance, configs []relabelConfig) []Instance {
+	if len(configs) == 0 {
+		return instances
+	}
+
+	options := defaultTargetOptions()
+	relabeled := []Instance{}
+	for _, instance := range instances {
+		iface, _ := selectInterface(instance, interfacePrimary)
+		labels := instanceLabels(instance, iface, parseScrapeAnnotations(instance.Name, instance.Tags))
+		labels["__address__"] = options.address(iface)
+
+		if !relabel(labels, configs) {
+			continue
+		}
+		instance.Labels = labels
+		relabeled = append(relabeled, instance)
+	}
+	return relabeled
+}
+
+// relabel applies the rules to a label set in place and reports whether the target is kept
+func relabel(labels map[string]string, configs []relabelConfig) bool {
+	for _, config := range configs {
+		values := make([]string, 0, len(config.SourceLabels))
+		for _, name := range config.SourceLabels {
+			values = append(values, labels[name])
+		}
+		value := strings.Join(values, config.separator())
+
+		switch config.Action {
+		case "keep":
+			if !config.regex.MatchString(value) {
+				return false
+			}
+		case "drop":
+			if config.regex.MatchString(value) {
+				return false
+			}
+		case "replace":
+			match := config.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(config.regex.ExpandString(nil, config.TargetLabel, value, match))
+			result := string(config.regex.ExpandString(nil, config.replacement(), value, match))
+			if !labelNamePattern.MatchString(target) {
+				continue // A capture group expanded to an invalid name, Prometheus skips the rule too
+			}
+			if result == "" {
+				delete(labels, target)
+			} else {
+				labels[target] = result
+			}
+		case "hashmod":
+			sum := md5.Sum([]byte(value))
+			labels[config.TargetLabel] = fmt.Sprintf("%d", binary.BigEndian.Uint64(sum[8:])%config.Modulus)
+		case "labelmap":
+			for _, name := range sortedLabelNames(labels) {
+				if match := config.regex.FindStringSubmatchIndex(name); match != nil {
+					labels[string(config.regex.ExpandString(nil, config.replacement(), name, match))] = labels[name]
+				}
+			}
+		case "labeldrop":
+			for _, name := range sortedLabelNames(labels) {
+				if config.regex.MatchString(name) {
+					delete(labels, name)
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (c relabelConfig) separator() string {
+	if c.Separator == nil {
+		return ";"
+	}
+	return *c.Separator
+}
+
+func (c relabelConfig) replacement() string {
+	if c.Replacement == nil {
+		return "$1"
+	}
+	return *c.Replacement
+}
+
+// sortedLabelNames returns a snapshot of the label names, so rules can modify the map while iterating
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
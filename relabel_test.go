@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// mustRelabelConfigs loads relabel rules the way they are read from config.json
+func mustRelabelConfigs(t *testing.T, rules []map[string]interface{}) []relabelConfig {
+	t.Helper()
+	viper.Set("test_relabel_configs", rules)
+	defer viper.Set("test_relabel_configs", nil)
+
+	configs, err := loadRelabelConfigs("test_relabel_configs")
+	if err != nil {
+		t.Fatalf("loadRelabelConfigs: %v", err)
+	}
+	return configs
+}
+
+// prometheusHashmod is hashmod as upstream Prometheus computes it: the md5 sum folded into a uint64, where
+// only the last 8 bytes survive the shifts
+func prometheusHashmod(value string, modulus uint64) string {
+	hash := md5.Sum([]byte(value))
+	var sum uint64
+	for i, b := range hash {
+		shift := uint64((md5.Size - 1 - i) * 8)
+		sum |= uint64(b) << shift
+	}
+	return fmt.Sprintf("%d", sum%modulus)
+}
+
+func TestRelabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  map[string]string
+		rules  []map[string]interface{}
+		output map[string]string // nil when the target is dropped
+	}{
+		{
+			name:  "replace with capture groups",
+			input: map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "f(.*)", "target_label": "d", "replacement": "ch${1}-ch${1}"},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "choo-choo"},
+		},
+		{
+			name:  "replace joins source labels with the separator",
+			input: map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a", "b"}, "regex": "f(.*);(.*)r", "target_label": "a", "replacement": "b${1}${2}m"},
+				{"source_labels": []string{"c", "a"}, "separator": "|", "regex": "(.*)\\|(.*)", "target_label": "d", "replacement": "$2-$1"},
+			},
+			output: map[string]string{"a": "boobam", "b": "bar", "c": "baz", "d": "boobam-baz"},
+		},
+		{
+			name:  "replace defaults copy the whole value",
+			input: map[string]string{"a": "foo"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "target_label": "b"},
+			},
+			output: map[string]string{"a": "foo", "b": "foo"},
+		},
+		{
+			name:  "replace regex is anchored",
+			input: map[string]string{"a": "foo"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "o", "target_label": "b", "replacement": "matched"},
+			},
+			output: map[string]string{"a": "foo"},
+		},
+		{
+			name:  "replace with a templated target label",
+			input: map[string]string{"a": "some-name-value"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "some-([^-]+)-([^,]+)", "target_label": "${1}", "replacement": "${2}"},
+			},
+			output: map[string]string{"a": "some-name-value", "name": "value"},
+		},
+		{
+			name:  "replace with an empty result deletes the label",
+			input: map[string]string{"a": "foo", "b": "bar"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "foo", "target_label": "b", "replacement": ""},
+			},
+			output: map[string]string{"a": "foo"},
+		},
+		{
+			name:  "replace from a missing label sees an empty value",
+			input: map[string]string{"a": "foo"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"missing"}, "regex": "", "target_label": "b", "replacement": "empty"},
+			},
+			output: map[string]string{"a": "foo", "b": "empty"},
+		},
+		{
+			name:  "replace skips a target label that expands to an invalid name",
+			input: map[string]string{"a": "1-value"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "(.*)-(.*)", "target_label": "${1}", "replacement": "${2}"},
+			},
+			output: map[string]string{"a": "1-value"},
+		},
+		{
+			name:  "keep matching",
+			input: map[string]string{"a": "foo"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "f.*", "action": "keep"},
+			},
+			output: map[string]string{"a": "foo"},
+		},
+		{
+			name:  "keep not matching",
+			input: map[string]string{"a": "foo"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "no-match", "action": "keep"},
+			},
+			output: nil,
+		},
+		{
+			name:  "drop matching",
+			input: map[string]string{"a": "foo", "b": "bar"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": ".*o.*", "action": "drop"},
+			},
+			output: nil,
+		},
+		{
+			name:  "drop not matching",
+			input: map[string]string{"a": "foo", "b": "bar"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "regex": "f", "action": "drop"},
+			},
+			output: map[string]string{"a": "foo", "b": "bar"},
+		},
+		{
+			name:  "hashmod",
+			input: map[string]string{"a": "foo", "b": "bar", "c": "baz"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"c"}, "target_label": "d", "modulus": 1000, "action": "hashmod"},
+				{"source_labels": []string{"a", "b"}, "target_label": "e", "modulus": 7, "action": "hashmod"},
+			},
+			output: map[string]string{"a": "foo", "b": "bar", "c": "baz", "d": "976", "e": prometheusHashmod("foo;bar", 7)},
+		},
+		{
+			name:  "labelmap",
+			input: map[string]string{"a": "foo", "tag_env": "prod", "tag_team": "sre"},
+			rules: []map[string]interface{}{
+				{"regex": "tag_(.+)", "replacement": "team_$1", "action": "labelmap"},
+			},
+			output: map[string]string{"a": "foo", "tag_env": "prod", "tag_team": "sre", "team_env": "prod", "team_team": "sre"},
+		},
+		{
+			name:  "labelmap default replacement",
+			input: map[string]string{"__meta_env": "prod"},
+			rules: []map[string]interface{}{
+				{"regex": "__meta_(.+)", "action": "labelmap"},
+			},
+			output: map[string]string{"__meta_env": "prod", "env": "prod"},
+		},
+		{
+			name:  "labeldrop",
+			input: map[string]string{"a": "foo", "b1": "bar", "b2": "baz"},
+			rules: []map[string]interface{}{
+				{"regex": "b.*", "action": "labeldrop"},
+			},
+			output: map[string]string{"a": "foo"},
+		},
+		{
+			name:  "labeldrop regex is anchored",
+			input: map[string]string{"a": "foo", "ab": "bar"},
+			rules: []map[string]interface{}{
+				{"regex": "b", "action": "labeldrop"},
+			},
+			output: map[string]string{"a": "foo", "ab": "bar"},
+		},
+		{
+			name:  "rules apply in order",
+			input: map[string]string{"a": "foo"},
+			rules: []map[string]interface{}{
+				{"source_labels": []string{"a"}, "target_label": "b", "replacement": "bar"},
+				{"source_labels": []string{"b"}, "regex": "bar", "action": "drop"},
+			},
+			output: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configs := mustRelabelConfigs(t, test.rules)
+			labels := make(map[string]string, len(test.input))
+			for name, value := range test.input {
+				labels[name] = value
+			}
+
+			kept := relabel(labels, configs)
+			if test.output == nil {
+				if kept {
+					t.Errorf("target kept with %v, want it dropped", labels)
+				}
+				return
+			}
+			if !kept {
+				t.Fatalf("target dropped, want %v", test.output)
+			}
+			if !reflect.DeepEqual(labels, test.output) {
+				t.Errorf("labels = %v, want %v", labels, test.output)
+			}
+		})
+	}
+}
+
+func TestHashmodMatchesPrometheus(t *testing.T) {
+	configs := mustRelabelConfigs(t, []map[string]interface{}{
+		{"source_labels": []string{"__address__"}, "target_label": "shard", "modulus": 16, "action": "hashmod"},
+	})
+	for _, address := range []string{"10.0.0.1:9100", "10.0.0.2:9100", "[2001:db8::5]:9100", ""} {
+		labels := map[string]string{"__address__": address}
+		relabel(labels, configs)
+		if want := prometheusHashmod(address, 16); labels["shard"] != want {
+			t.Errorf("hashmod(%q) = %s, want %s", address, labels["shard"], want)
+		}
+	}
+}
+
+func TestLoadRelabelConfigsErrors(t *testing.T) {
+	tests := []struct {
+		rule map[string]interface{}
+		err  string
+	}{
+		{map[string]interface{}{"source_labels": []string{"a"}}, "replace requires target_label"},
+		{map[string]interface{}{"action": "hashmod", "target_label": "shard"}, "hashmod requires target_label and a modulus above 0"},
+		{map[string]interface{}{"action": "keep", "regex": "("}, `invalid regex "("`},
+		{map[string]interface{}{"action": "labelkeep"}, `unsupported action "labelkeep"`},
+		{map[string]interface{}{"source_labels": []string{"a"}, "target_label": "1abc"}, `"1abc" is not a valid target_label`},
+		{map[string]interface{}{"source_labels": []string{"a"}, "target_label": "env-name"}, `"env-name" is not a valid target_label`},
+		{map[string]interface{}{"action": "hashmod", "target_label": "${1}", "modulus": 4}, `"${1}" is not a valid label name`},
+		{map[string]interface{}{"action": "labelmap", "regex": "tag_(.+)", "replacement": "tag-$1"}, `"tag-$1" is not a valid labelmap replacement`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.err, func(t *testing.T) {
+			viper.Set("test_relabel_configs", []map[string]interface{}{test.rule})
+			defer viper.Set("test_relabel_configs", nil)
+
+			_, err := loadRelabelConfigs("test_relabel_configs")
+			if err == nil || !strings.Contains(err.Error(), "test_relabel_configs[0]: "+test.err) {
+				t.Errorf("loadRelabelConfigs error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestApplyRelabelConfigs(t *testing.T) {
+	configs := mustRelabelConfigs(t, []map[string]interface{}{
+		{"source_labels": []string{"__address__"}, "regex": "(.*):9100", "target_label": "__address__", "replacement": "$1:9182"},
+		{"source_labels": []string{"__address__"}, "regex": "10\\.0\\.0\\.2:.*", "action": "drop"},
+		{"source_labels": []string{"__address__"}, "target_label": "address"},
+	})
+
+	groups := applyRelabelConfigs([]TargetGroup{
+		{Targets: []string{"10.0.0.1:9100", "10.0.0.2:9100", "10.0.0.3:8080"}, Labels: map[string]string{"job": "node"}},
+	}, configs)
+
+	want := []TargetGroup{
+		{Targets: []string{"10.0.0.1:9182"}, Labels: map[string]string{"job": "node", "address": "10.0.0.1:9182"}},
+		{Targets: []string{"10.0.0.3:8080"}, Labels: map[string]string{"job": "node", "address": "10.0.0.3:8080"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("applyRelabelConfigs = %+v, want %+v", groups, want)
+	}
+
+	unchanged := []TargetGroup{{Targets: []string{"a", "b"}}}
+	if got := applyRelabelConfigs(unchanged, nil); !reflect.DeepEqual(got, unchanged) {
+		t.Errorf("applyRelabelConfigs without rules = %+v, want the groups unchanged", got)
+	}
+}
+
+func TestRelabelInstances(t *testing.T) {
+	configs := mustRelabelConfigs(t, []map[string]interface{}{
+		{"source_labels": []string{"status"}, "regex": "running", "action": "keep"},
+		{"source_labels": []string{"__address__"}, "target_label": "address"},
+		{"regex": "tag_(.+)", "action": "labelmap"},
+	})
+
+	instances := relabelInstances([]Instance{
+		{Name: "web-1", Status: "running", PrivateIP: "10.0.0.1", Tags: []string{"env:prod"}},
+		{Name: "web-2", Status: "stopped", PrivateIP: "10.0.0.2"},
+	}, configs)
+
+	if len(instances) != 1 || instances[0].Name != "web-1" {
+		t.Fatalf("relabelInstances kept %+v, want only web-1", instances)
+	}
+	labels := instances[0].Labels
+	if labels["__address__"] != "10.0.0.1" || labels["address"] != "10.0.0.1" || labels["env"] != "prod" || labels["instance"] != "web-1" {
+		t.Errorf("labels = %v, want __address__, address, env and instance set", labels)
+	}
+
+	raw := []Instance{{Name: "web-1"}}
+	if got := relabelInstances(raw, nil); !reflect.DeepEqual(got, raw) {
+		t.Errorf("relabelInstances without rules = %+v, want the instances unchanged", got)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaultResourceGroupCacheTTL is how long the resource groups of an account are reused before listing them again
+const defaultResourceGroupCacheTTL = time.Hour
+
+// resourceGroup is a resource group resolved within an account
+type resourceGroup struct {
+	ID   string
+	Name string
+}
+
+// resourceGroupEntry holds the resource groups of one IBM Cloud account
+type resourceGroupEntry struct {
+	groups    []resourceGroup
+	fetchedAt time.Time
+}
+
+var (
+	resourceGroupMu      sync.Mutex                            // Guards the two maps below, never held across API calls
+	resourceGroupCache   = make(map[string]resourceGroupEntry) // Keyed by IBM Cloud account ID
+	resourceGroupFetches = make(map[string]*sync.Mutex)        // Serializes the listings of one account
+	accountIDCache       = sync.Map{}                          // Client fingerprint -> IBM Cloud account ID
+)
+
+// resourceGroupCacheTTL returns resource_group_cache_ttl from config.json, e.g. "30m"
+func resourceGroupCacheTTL() time.Duration {
+	if value := viper.GetString("resource_group_cache_ttl"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err == nil {
+			return ttl
+		}
+		log.Printf("⚠️ Warning: Invalid resource_group_cache_ttl '%s', using %s: %v", value, defaultResourceGroupCacheTTL, err)
+	}
+	return defaultResourceGroupCacheTTL
+}
+
+// resolveResourceGroup resolves a resource group given by name or ID within the account owning the API key.
+// Resource groups with the same name in different accounts, such as default, resolve to their own IDs.
+func resolveResourceGroup(apiKey, account, nameOrID string) (resourceGroup, error) {
+	clients := clientsFor(account, apiKey)
+	accountID, err := lookupAccountID(clients)
+	if err != nil {
+		return resourceGroup{}, fmt.Errorf("failed to determine the account ID of %s: %v", maskAccount(account), err)
+	}
+
+	groups, err := accountResourceGroups(clients, accountID, false)
+	if err != nil {
+		return resourceGroup{}, err
+	}
+	if group, found := findResourceGroup(groups, nameOrID); found {
+		return group, nil
+	}
+
+	// The group may have been created since the last listing
+	groups, err = accountResourceGroups(clients, accountID, true)
+	if err != nil {
+		return resourceGroup{}, err
+	}
+	if group, found := findResourceGroup(groups, nameOrID); found {
+		return group, nil
+	}
+
+	log.Printf("❌ Resource group '%s' not found in account %s", nameOrID, maskAccount(account))
+	return resourceGroup{}, fmt.Errorf("resource group %s not found", nameOrID)
+}
+
+func findResourceGroup(groups []resourceGroup, nameOrID string) (resourceGroup, bool) {
+	for _, group := range groups {
+		if group.ID == nameOrID || group.Name == nameOrID {
+			return group, true
+		}
+	}
+	return resourceGroup{}, false
+}
+
+// accountResourceGroups returns the cached resource groups of an account, listing them again once the TTL
+// has expired or when refresh is set. Only one listing per account runs at a time; callers waiting for it use
+// its result, while other accounts are not blocked.
+func accountResourceGroups(clients *accountClients, accountID string, refresh bool) ([]resourceGroup, error) {
+	requested := time.Now()
+	fetchMu := resourceGroupFetchLock(accountID)
+	fetchMu.Lock()
+	defer fetchMu.Unlock()
+
+	resourceGroupMu.Lock()
+	entry, found := resourceGroupCache[accountID]
+	resourceGroupMu.Unlock()
+	if found && time.Since(entry.fetchedAt) < resourceGroupCacheTTL() && (!refresh || entry.fetchedAt.After(requested)) {
+		return entry.groups, nil
+	}
+
+	resourceManagerService, err := clients.resourceManagerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	options := resourceManagerService.NewListResourceGroupsOptions()
+	options.SetAccountID(accountID)
+	result, _, err := retryCall("resource_manager", "ListResourceGroups", resourceManagerService.ListResourceGroups, options)
+	if err != nil {
+		if found {
+			log.Printf("⚠️ Warning: Failed to refresh resource groups of account %s, using cached list: %v", maskAccount(clients.account), err)
+			return entry.groups, nil
+		}
+		return nil, fmt.Errorf("failed to list resource groups: %v", err)
+	}
+
+	groups := []resourceGroup{}
+	for _, group := range result.Resources {
+		groups = append(groups, resourceGroup{ID: stringValue(group.ID), Name: stringValue(group.Name)})
+	}
+	resourceGroupMu.Lock()
+	resourceGroupCache[accountID] = resourceGroupEntry{groups: groups, fetchedAt: time.Now()}
+	resourceGroupMu.Unlock()
+	log.Printf("✅ Loaded %d resource groups of account %s", len(groups), maskAccount(clients.account))
+	return groups, nil
+}
+
+// resourceGroupFetchLock returns the lock serializing the resource group listings of an account
+func resourceGroupFetchLock(accountID string) *sync.Mutex {
+	resourceGroupMu.Lock()
+	defer resourceGroupMu.Unlock()
+	fetchMu, found := resourceGroupFetches[accountID]
+	if !found {
+		fetchMu = &sync.Mutex{}
+		resourceGroupFetches[accountID] = fetchMu
+	}
+	return fetchMu
+}
+
+// lookupAccountID reads the IBM Cloud account ID from the account.bss claim of the IAM access token. It is
+// cached by the fingerprint of the clients, so the API key itself is not kept as a map key.
+func lookupAccountID(clients *accountClients) (string, error) {
+	if accountID, found := accountIDCache.Load(clients.fingerprint); found {
+		return accountID.(string), nil
+	}
+
+	token, err := clients.authenticator.GetToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to get IAM token: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("IAM token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode IAM token: %v", err)
+	}
+
+	var claims struct {
+		Account struct {
+			BSS string `json:"bss"`
+		} `json:"account"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("failed to parse IAM token: %v", err)
+	}
+	if claims.Account.BSS == "" {
+		return "", fmt.Errorf("IAM token has no account claim")
+	}
+
+	accountIDCache.Store(clients.fingerprint, claims.Account.BSS)
+	return claims.Account.BSS, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/spf13/viper"
+)
+
+// retryPolicy controls how often and how long a failed IBM Cloud API call is retried. Only throttling (429),
+// server errors (5xx) and transient network errors (timeouts, reset or dropped connections) are retried.
+type retryPolicy struct {
+	MaxAttempts    int    `mapstructure:"max_attempts"`    // Total attempts including the first, 1 disables retries
+	InitialBackoff string `mapstructure:"initial_backoff"` // Backoff before the first retry, doubled for every further retry
+	MaxBackoff     string `mapstructure:"max_backoff"`     // Upper bound of a backoff, also for Retry-After
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{MaxAttempts: 4, initialBackoff: 500 * time.Millisecond, maxBackoff: 30 * time.Second}
+
+var (
+	retryPoliciesMu sync.Mutex
+	retryPolicies   = make(map[string]retryPolicy) // Keyed by API, loaded on first use
+)
+
+// policyFor returns the retry policy of an API: retry.<api> from config.json on top of retry.default
+func policyFor(api string) retryPolicy {
+	retryPoliciesMu.Lock()
+	defer retryPoliciesMu.Unlock()
+	if policy, found := retryPolicies[api]; found {
+		return policy
+	}
+
+	policy := defaultRetryPolicy
+	for _, key := range []string{"retry.default", "retry." + api} {
+		if !viper.IsSet(key) {
+			continue
+		}
+		var configured retryPolicy
+		if err := viper.UnmarshalKey(key, &configured); err != nil {
+			log.Printf("⚠️ Warning: Invalid %s in config.json: %v", key, err)
+			continue
+		}
+		if configured.MaxAttempts > 0 {
+			policy.MaxAttempts = configured.MaxAttempts
+		}
+		if duration, ok := parsePolicyDuration(key+".initial_backoff", configured.InitialBackoff); ok {
+			policy.initialBackoff = duration
+		}
+		if duration, ok := parsePolicyDuration(key+".max_backoff", configured.MaxBackoff); ok {
+			policy.maxBackoff = duration
+		}
+	}
+
+	retryPolicies[api] = policy
+	return policy
+}
+
+func parsePolicyDuration(key, value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("⚠️ Warning: Invalid %s '%s' in config.json: %v", key, value, err)
+		return 0, false
+	}
+	return duration, true
+}
+
+// backoff returns the delay before the given retry (1 for the first): full jitter over an exponentially
+// growing window, or the server's Retry-After when it sent one
+func (p retryPolicy) backoff(retry int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, p.maxBackoff)
+	}
+	window := p.initialBackoff << (retry - 1)
+	if window <= 0 || window > p.maxBackoff {
+		window = p.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(window) + 1))
+}
+
+// retryReason returns why a failed call may be retried, or an empty string when it must not be
+func retryReason(statusCode int, err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case statusCode == 0:
+		if transientNetworkError(err) {
+			return "network"
+		}
+		return ""
+	case statusCode == http.StatusTooManyRequests:
+		return "throttled"
+	case statusCode >= 500:
+		return "server_error"
+	}
+	return ""
+}
+
+// transientNetworkError reports whether a call failed in transport in a way another attempt can fix: a
+// timeout or a connection the server reset or closed. Errors of invalid options, request building or
+// hosts that do not resolve are permanent. The SDK keeps the transport error in its problem chain.
+func transientNetworkError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(headers http.Header) time.Duration {
+	value := headers.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// withRetry runs an attempt until it succeeds, fails for a reason that is not retried, or the attempts of the
+// API's policy are used up. attempt returns the HTTP status (0 without response) and headers of the call.
+func withRetry(api, operation string, attempt func() (int, http.Header, error)) error {
+	policy := policyFor(api)
+	for try := 1; ; try++ {
+		apiMetrics.call(api)
+		statusCode, headers, err := attempt()
+		reason := retryReason(statusCode, err)
+		if reason == "" {
+			if err != nil {
+				apiMetrics.fail(api)
+			}
+			return err
+		}
+		if try >= policy.MaxAttempts {
+			apiMetrics.fail(api)
+			if try > 1 {
+				return fmt.Errorf("%v (gave up after %d attempts)", err, try)
+			}
+			return err
+		}
+
+		delay := policy.backoff(try, parseRetryAfter(headers))
+		apiMetrics.retry(api, reason)
+		log.Printf("🔁 %s %s failed (%s, HTTP %d), retry %d/%d in %s: %v",
+			api, operation, reason, statusCode, try, policy.MaxAttempts-1, delay.Round(time.Millisecond), err)
+		time.Sleep(delay)
+	}
+}
+
+// retryCall runs an IBM Cloud SDK call with the retry policy of the API, e.g.
+//
+//	result, response, err := retryCall("vpc", "ListInstances", vpcService.ListInstances, options)
+func retryCall[O, T any](api, operation string, call func(*O) (T, *core.DetailedResponse, error), options *O) (T, *core.DetailedResponse, error) {
+	var result T
+	var response *core.DetailedResponse
+	err := withRetry(api, operation, func() (int, http.Header, error) {
+		var err error
+		result, response, err = call(options)
+		return statusCode(response), responseHeaders(response), err
+	})
+	return result, response, err
+}
+
+// retryRequest sends a REST request with the retry policy of the API and returns the response of the first
+// successful attempt. Any status but 200 OK is an error; the caller closes the body.
+func retryRequest(api, operation string, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var resp *http.Response
+	err := withRetry(api, operation, func() (int, http.Header, error) {
+		req, err := newRequest()
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to build request: %v", err) // Not retried
+		}
+		resp, err = ibmHTTPClient.Do(req)
+		if err != nil {
+			return 0, nil, fmt.Errorf("request to %s failed: %w", maskURL(req.URL.String()), err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return resp.StatusCode, resp.Header, fmt.Errorf("request to %s failed (HTTP %d)", maskURL(req.URL.String()), resp.StatusCode)
+		}
+		return resp.StatusCode, resp.Header, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// statusCode returns the HTTP status of an SDK response, 0 when the call failed without one
+func statusCode(response *core.DetailedResponse) int {
+	if response == nil {
+		return 0
+	}
+	return response.StatusCode
+}
+
+func responseHeaders(response *core.DetailedResponse) http.Header {
+	if response == nil {
+		return nil
+	}
+	return response.Headers
+}
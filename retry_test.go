@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/IBM/go-sdk-core/v5/core"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryReason(t *testing.T) {
+	component := core.NewProblemComponent("test", "1.0.0")
+	reset := &url.Error{Op: "Get", URL: "https://us-south.iaas.cloud.ibm.com/v1/instances",
+		Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}
+	notFound := &url.Error{Op: "Get", URL: "https://ams03.iaas.cloud.ibm.com/v1/instances",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "ams03.iaas.cloud.ibm.com", IsNotFound: true}}}
+	dnsTimeout := &net.DNSError{Err: "i/o timeout", Name: "us-south.iaas.cloud.ibm.com", IsTimeout: true}
+
+	tests := []struct {
+		name       string
+		statusCode int
+		err        error
+		want       string
+	}{
+		{"success", 200, nil, ""},
+		{"throttled", 429, errors.New("too many requests"), "throttled"},
+		{"server error", 503, errors.New("unavailable"), "server_error"},
+		{"forbidden", 403, errors.New("forbidden"), ""},
+		{"timeout", 0, &url.Error{Op: "Get", URL: "https://example.com", Err: timeoutError{}}, "network"},
+		{"connection reset", 0, reset, "network"},
+		{"connection reset in sdk problem", 0, core.SDKErrorf(reset, "", "no-connection-made", component), "network"},
+		{"connection closed", 0, fmt.Errorf("request to example.com failed: %w", &url.Error{Op: "Get", URL: "https://example.com", Err: io.EOF}), "network"},
+		{"dns timeout", 0, dnsTimeout, "network"},
+		{"host not found", 0, notFound, ""},
+		{"host not found in sdk problem", 0, core.SDKErrorf(notFound, "", "no-connection-made", component), ""},
+		{"invalid options", 0, core.SDKErrorf(errors.New("VPCID must be set"), "", "struct-validation-error", component), ""},
+		{"request building", 0, fmt.Errorf("failed to build request: %v", reset), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retryReason(test.statusCode, test.err); got != test.want {
+				t.Errorf("retryReason(%d, %v) = %q, want %q", test.statusCode, test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Tags declaring how an instance is scraped, like the prometheus.io annotations on Kubernetes pods
+const (
+	tagScrapePort   = "prometheus_port"   // May be repeated, one target per port
+	tagMetricsPath  = "prometheus_path"   // Sets __metrics_path__
+	tagScrapeScheme = "prometheus_scheme" // Sets __scheme__, http or https
+)
+
+// scrapeAnnotations is the scrape configuration an instance declares through its tags
+type scrapeAnnotations struct {
+	Ports  []string
+	Path   string
+	Scheme string
+}
+
+// parseScrapeAnnotations reads the prometheus_* tags of an instance, ignoring invalid values
+func parseScrapeAnnotations(instanceName string, tags []string) scrapeAnnotations {
+	var annotations scrapeAnnotations
+	for _, tag := range tags {
+		key, value, isKeyValue := splitTag(tag)
+		if !isKeyValue {
+			continue
+		}
+
+		switch key {
+		case tagScrapePort:
+			if _, err := strconv.ParseUint(value, 10, 16); err != nil {
+				log.Printf("⚠️ Instance %s has an invalid %s tag %q, ignoring it", instanceName, tagScrapePort, value)
+				continue
+			}
+			if !contains(annotations.Ports, value) {
+				annotations.Ports = append(annotations.Ports, value)
+			}
+		case tagMetricsPath:
+			if value == "" {
+				log.Printf("⚠️ Instance %s has an empty %s tag, ignoring it", instanceName, tagMetricsPath)
+				continue
+			}
+			// IBM Cloud tags cannot contain "/", so the leading slash is optional
+			if !strings.HasPrefix(value, "/") {
+				value = "/" + value
+			}
+			annotations.Path = value
+		case tagScrapeScheme:
+			if value != "http" && value != "https" {
+				log.Printf("⚠️ Instance %s has an invalid %s tag %q, ignoring it", instanceName, tagScrapeScheme, value)
+				continue
+			}
+			annotations.Scheme = value
+		}
+	}
+
+	sort.Strings(annotations.Ports) // Tag order is not stable
+	return annotations
+}
+
+// targets returns one target per declared port, or address:defaultPort when the tags declare none.
+// Without a default port the bare address is returned and Prometheus applies the scheme's port.
+func (a scrapeAnnotations) targets(address, defaultPort string) []string {
+	if len(a.Ports) == 0 {
+		if defaultPort == "" {
+			return []string{targetHost(address)}
+		}
+		return []string{net.JoinHostPort(address, defaultPort)}
+	}
+
+	targets := []string{}
+	for _, port := range a.Ports {
+		targets = append(targets, net.JoinHostPort(address, port))
+	}
+	return targets
+}
+
+// addLabels sets the reserved labels Prometheus uses for the metrics path and scheme of a target
+func (a scrapeAnnotations) addLabels(labels map[string]string) {
+	if a.Path != "" {
+		labels["__metrics_path__"] = a.Path
+	}
+	if a.Scheme != "" {
+		labels["__scheme__"] = a.Scheme
+	}
+}
+
+// addTagLabels exposes instance tags as labels: key:value tags become tag_<key>="<value>" and every tag is
+// listed in tags=",a,b,". Keys are filtered through tag_keys_allow/tag_keys_deny from config.json.
+func addTagLabels(labels map[string]string, prefix string, tags []string) {
+	allow := viper.GetStringSlice("tag_keys_allow")
+	deny := viper.GetStringSlice("tag_keys_deny")
+
+	// Sort so labels don't change when the tagging API returns tags in a different order
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+
+	listed := []string{}
+	values := make(map[string][]string)
+	for _, tag := range sorted {
+		key, value, isKeyValue := splitTag(tag)
+		if (len(allow) > 0 && !contains(allow, key)) || contains(deny, key) {
+			continue
+		}
+
+		listed = append(listed, tag)
+		if isKeyValue {
+			name := sanitizeLabelName(key)
+			values[name] = append(values[name], value)
+		}
+	}
+
+	// A key may be tagged several times (env:dev and env:test), its values are joined
+	for name, tagValues := range values {
+		labels[prefix+"tag_"+name] = strings.Join(tagValues, ",")
+	}
+
+	// Surrounding separators let relabel regexes match ",tag,"
+	if len(listed) > 0 {
+		labels[prefix+"tags"] = "," + strings.Join(listed, ",") + ","
+	}
+}
+
+// splitTag splits a key:value tag; plain tags are returned as their own key
+func splitTag(tag string) (string, string, bool) {
+	key, value, found := strings.Cut(tag, ":")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return tag, "", false
+	}
+	return key, strings.TrimSpace(value), true
+}
+
+// sanitizeLabelName replaces every character that is not valid in a Prometheus label name with an underscore
+func sanitizeLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
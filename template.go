@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+)
+
+// targetTemplateData is what a target template is executed against: every Instance field, plus the
+// interface and address picked by the interface/prefer_ipv6 options
+type targetTemplateData struct {
+	Instance
+	Interface NetworkInterface
+	Address   string
+}
+
+var configTargetTemplate *template.Template // target_template from config.json, nil when unset
+
+// parseTargetTemplate compiles a target template such as `{{.PrivateIP}}:{{tag "exporter_port" "9100"}}` and
+// rejects it if it yields an invalid target for an instance that has every field set
+func parseTargetTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("target").Option("missingkey=error").Funcs(template.FuncMap{
+		// Placeholders, replaced per instance in executeTargetTemplate
+		"tag":    func(key string, defaultValue ...string) string { return "" },
+		"hasTag": func(tag string) bool { return false },
+		"lower":  strings.ToLower,
+		"upper":  strings.ToUpper,
+		"replace": func(old, new, s string) string {
+			return strings.ReplaceAll(s, old, new)
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target template: %v", err)
+	}
+
+	sample := Instance{
+		Name: "sample", ID: "0000", Region: "us-east", Account: "account", AvailabilityZone: "us-east-1",
+		PrivateIP: "10.0.0.1", PublicIP: "192.0.2.1", PrivateIPv6: "2001:db8::1", PublicIPv6: "2001:db8::2",
+		Status: "running", InstanceID: "crn", Profile: "profile", ResourceType: resourceTypeInstance,
+		ResourceGroup: "default", Hostname: "sample", Domain: "example.com",
+	}
+	iface := NetworkInterface{Name: "eth0", PrimaryIP: sample.PrivateIP, FloatingIP: sample.PublicIP, Primary: true}
+	if _, err := executeTargetTemplate(tmpl, sample, iface, sample.PrivateIP); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// instanceTargets returns the targets of an instance: the rendered template when one is configured, otherwise
+// the selected address with the ports declared in its tags or the default port
+func instanceTargets(instance Instance, iface NetworkInterface, options targetOptions, annotations scrapeAnnotations) ([]string, error) {
+	address := options.address(iface)
+	if options.Template == nil {
+		return annotations.targets(address, options.Port), nil
+	}
+
+	target, err := executeTargetTemplate(options.Template, instance, iface, address)
+	if err != nil {
+		return nil, err
+	}
+	return []string{target}, nil
+}
+
+// executeTargetTemplate renders the target of one instance and checks it is a usable address
+func executeTargetTemplate(tmpl *template.Template, instance Instance, iface NetworkInterface, address string) (string, error) {
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"tag": func(key string, defaultValue ...string) string {
+			for _, tag := range instance.Tags {
+				if tagKey, value, isKeyValue := splitTag(tag); isKeyValue && tagKey == key {
+					return value
+				}
+			}
+			if len(defaultValue) > 0 {
+				return defaultValue[0]
+			}
+			return ""
+		},
+		"hasTag": func(tag string) bool { return contains(instance.Tags, tag) },
+	})
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, targetTemplateData{Instance: instance, Interface: iface, Address: address}); err != nil {
+		return "", fmt.Errorf("target template failed: %v", err)
+	}
+
+	target := strings.TrimSpace(out.String())
+	if target == "" {
+		return "", fmt.Errorf("target template produced an empty target")
+	}
+	// "host:port" must have both parts, e.g. {{.PublicIP}}:443 on an instance without public IP
+	if host, port, err := net.SplitHostPort(target); err == nil && (host == "" || port == "") {
+		return "", fmt.Errorf("target template produced %q, which lacks a host or port", target)
+	}
+	return target, nil
+}